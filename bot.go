@@ -1,9 +1,10 @@
 package musicbot
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
-	"log"
 	"sync"
 
 	"github.com/boltdb/bolt"
@@ -17,14 +18,17 @@ type Bot struct {
 	discord  *discordgo.Session
 	db       *boltGuildStorage
 	commands []command
-	plugins  []plugins.Plugin
+	sources  []plugins.Source
+	audio    *plugins.AudioCache
 
 	mu     sync.RWMutex
 	guilds map[string]*Guild
 }
 
 // New starts a musicbot server.
-func New(token string, dbPath string, soundcloud string, youtube string) (*Bot, error) {
+// sources are tried in order, see plugins.Open.
+// audio keeps songs that have been played on disk, and can be nil.
+func New(token string, dbPath string, sources []plugins.Source, audio *plugins.AudioCache) (*Bot, error) {
 	db, err := newBoltGuildStorage(dbPath)
 	if err != nil {
 		return nil, err
@@ -43,31 +47,39 @@ func New(token string, dbPath string, soundcloud string, youtube string) (*Bot,
 		commands: []command{
 			help,
 			playlist,
+			history,
+			search,
 			pause,
 			skip,
 			clear,
+			remove,
+			move,
+			jump,
+			seek,
+			shuffle,
 			requeue,
+			previous,
+			loop,
+			volume,
+			louder,
+			quieter,
+			fx,
 			reconnect,
 			get,
 			set,
 			setPlayback,
 			setListen,
 			unsetListen,
+			setDJ,
+			setPermission,
+			setSources,
+			library,
+			cache,
+			cancelLookups,
 		},
-		plugins: []plugins.Plugin{
-			plugins.Youtube{},
-			plugins.Soundcloud{ClientID: soundcloud},
-			plugins.Twitch{},
-			plugins.Bandcamp{},
-			plugins.Streamlink{},
-		},
-		guilds: make(map[string]*Guild),
-	}
-	youtubeSearch, err := plugins.NewYoutubeSearch(youtube)
-	if err == nil {
-		b.plugins = append(b.plugins, youtubeSearch)
-	} else {
-		log.Printf("failed to acquire youtube service %v", err)
+		sources: plugins.Cached(sources, db),
+		audio:   audio,
+		guilds:  make(map[string]*Guild),
 	}
 
 	discord.AddHandler(onGuildCreate(b))
@@ -134,6 +146,20 @@ func newBoltGuildStorage(dbPath string) (*boltGuildStorage, error) {
 			return err
 		}
 		_, err = tx.CreateBucketIfNotExists([]byte("songs"))
+		if err != nil {
+			return err
+		}
+		// queues were briefly saved in their own bucket
+		if tx.Bucket([]byte("songs")) != nil {
+			if err := tx.DeleteBucket([]byte("queues")); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte("history"))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte("resolved"))
 		return err
 	})
 	if err != nil {
@@ -165,3 +191,145 @@ func (db boltGuildStorage) Put(guildID string, info GuildConfig) error {
 		return bucket.Put([]byte(guildID), val)
 	})
 }
+
+func (db boltGuildStorage) Queue(guildID string) ([]QueuedSong, error) {
+	var songs []QueuedSong
+	err := db.View(func(tx *bolt.Tx) error {
+		val := tx.Bucket([]byte("songs")).Get([]byte(guildID))
+		if val == nil {
+			return nil
+		}
+		return json.Unmarshal(val, &songs)
+	})
+	return songs, err
+}
+
+// PutQueue saves the whole queue as one value, so that it is never saved partly in one order and partly in another.
+func (db boltGuildStorage) PutQueue(guildID string, songs []QueuedSong) error {
+	var val []byte
+	if len(songs) > 0 {
+		var err error
+		if val, err = json.Marshal(songs); err != nil {
+			return err
+		}
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("songs"))
+		// songs were briefly saved one per key in a bucket per guild
+		if bucket.Bucket([]byte(guildID)) != nil {
+			if err := bucket.DeleteBucket([]byte(guildID)); err != nil {
+				return err
+			}
+		}
+		if val == nil {
+			return bucket.Delete([]byte(guildID))
+		}
+		return bucket.Put([]byte(guildID), val)
+	})
+}
+
+// History returns up to n of the most recently played songs, most recent first.
+func (db boltGuildStorage) History(guildID string, n int) ([]PlayedSong, error) {
+	var songs []PlayedSong
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("history")).Bucket([]byte(guildID))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for key, val := c.Last(); key != nil && len(songs) < n; key, val = c.Prev() {
+			song := PlayedSong{}
+			if err := json.Unmarshal(val, &song); err != nil {
+				return err
+			}
+			songs = append(songs, song)
+		}
+		return nil
+	})
+	return songs, err
+}
+
+// PutHistory keeps only the most recent historyLength songs.
+func (db boltGuildStorage) PutHistory(guildID string, song PlayedSong) error {
+	val, err := json.Marshal(song)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket([]byte("history")).CreateBucketIfNotExists([]byte(guildID))
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(songKey(id), val); err != nil {
+			return err
+		}
+
+		var keys [][]byte
+		c := bucket.Cursor()
+		for key, _ := c.Last(); key != nil; key, _ = c.Prev() {
+			keys = append(keys, key)
+		}
+		for len(keys) > historyLength {
+			if err := bucket.Delete(keys[len(keys)-1]); err != nil {
+				return err
+			}
+			keys = keys[:len(keys)-1]
+		}
+		return nil
+	})
+}
+
+// GetResolved returns what a plugins.Cache stored, or nil.
+func (db boltGuildStorage) GetResolved(key string) (val []byte, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		// values are only good until the transaction ends
+		if v := tx.Bucket([]byte("resolved")).Get([]byte(key)); v != nil {
+			val = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return
+}
+
+func (db boltGuildStorage) PutResolved(key string, val []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("resolved")).Put([]byte(key), val)
+	})
+}
+
+func (db boltGuildStorage) DeleteResolved(key string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("resolved")).Delete([]byte(key))
+	})
+}
+
+func (db boltGuildStorage) SweepResolved(prefix string, expired func(val []byte) bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("resolved"))
+		// deleting while iterating would skip keys
+		var keys [][]byte
+		c := bucket.Cursor()
+		for key, val := c.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, val = c.Next() {
+			if expired(val) {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+		}
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// keys are big endian so that bolt iterates songs in the order they were saved
+func songKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
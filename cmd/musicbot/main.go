@@ -2,12 +2,15 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 
 	"github.com/BurntSushi/toml"
 	"github.com/jeffreymkabot/musicbot"
+	"github.com/jeffreymkabot/musicbot/plugins"
 )
 
 func main() {
@@ -19,19 +22,61 @@ func main() {
 	}
 
 	var cfg struct {
-		Token      string
-		Bolt       string
-		Soundcloud string
-		Youtube    string
+		Token string
+		Bolt  string
+		// Sources are the names of the sources of music to try, in order.
+		// If empty, every source is tried in plugins.DefaultOrder.
+		Sources  []string
+		Disabled []string
+		// Cache keeps songs that have been played on disk, in Dir, up to MaxSize megabytes.
+		Cache struct {
+			Dir     string
+			MaxSize int64
+		}
 	}
 	_, err := toml.DecodeFile(*cfgFile, &cfg)
 	if err != nil {
 		log.Fatalf("Error opening cfg file: %v", err)
 	}
+	// each source is configured by the section of the same name
+	var sections map[string]toml.Primitive
+	md, err := toml.DecodeFile(*cfgFile, &sections)
+	if err != nil {
+		log.Fatalf("Error opening cfg file: %v", err)
+	}
 
 	log.Printf("Using config %#v", cfg)
 
-	bot, err := musicbot.New(cfg.Token, cfg.Bolt, cfg.Soundcloud, cfg.Youtube)
+	order := cfg.Sources
+	if len(order) == 0 {
+		order = plugins.DefaultOrder
+	}
+	var enabled []string
+	for _, name := range order {
+		if !contains(cfg.Disabled, name) {
+			enabled = append(enabled, name)
+		}
+	}
+	sources := plugins.Open(enabled, func(name string, v interface{}) error {
+		section, ok := sections[name]
+		if !ok {
+			return nil
+		}
+		if md.Type(name) == "String" {
+			return decodeLegacy(md, name, section, v)
+		}
+		return md.PrimitiveDecode(section, v)
+	})
+
+	var audio *plugins.AudioCache
+	if cfg.Cache.Dir != "" {
+		audio, err = plugins.NewAudioCache(cfg.Cache.Dir, cfg.Cache.MaxSize<<20)
+		if err != nil {
+			log.Fatalf("Error opening audio cache: %v", err)
+		}
+	}
+
+	bot, err := musicbot.New(cfg.Token, cfg.Bolt, sources, audio)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
@@ -48,3 +93,34 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// legacyKeys are the settings that some sources took as a top level string before sources had sections,
+// e.g. youtube = "key" instead of [youtube] apikey = "key"
+var legacyKeys = map[string]string{
+	"youtube":    "apikey",
+	"soundcloud": "clientid",
+}
+
+// decodeLegacy decodes a top level string as the setting of a source that it used to be.
+func decodeLegacy(md toml.MetaData, name string, section toml.Primitive, v interface{}) error {
+	key, ok := legacyKeys[name]
+	if !ok {
+		return fmt.Errorf("%v should be a section", name)
+	}
+	var value string
+	if err := md.PrimitiveDecode(section, &value); err != nil {
+		return err
+	}
+	log.Printf("%v = \"...\" is deprecated, use a [%v] section with %v = \"...\"", name, name, key)
+	_, err := toml.Decode(fmt.Sprintf("%s = %s", key, strconv.Quote(value)), v)
+	return err
+}
+
+func contains(s []string, t string) bool {
+	for _, v := range s {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
@@ -2,12 +2,16 @@ package musicbot
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"log"
+	"math"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/fatih/structs"
@@ -23,8 +27,8 @@ type command struct {
 	usage string // should at least have usage
 	short string
 	long  string
-	// only run for the owner of the guild
-	ownerOnly bool
+	// only run for members with this level of trust, unless overridden in a guild (see permission)
+	permission Permission
 	// only run in a guild's whitelisted channels
 	restrictChannel bool
 	ack             string // must be an emoji, used to react on success
@@ -32,6 +36,37 @@ type command struct {
 	run             serviceFunc
 }
 
+// Permission is a level of trust needed to run a command.
+type Permission int
+
+// Permissions
+const (
+	PermissionEveryone Permission = iota
+	// members with a DJ role, or everyone if a guild has no DJ roles
+	PermissionDJ
+	PermissionOwner
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionEveryone:
+		return "everyone"
+	case PermissionDJ:
+		return "dj"
+	default:
+		return "owner"
+	}
+}
+
+func parsePermission(arg string) (Permission, bool) {
+	for p := PermissionEveryone; p <= PermissionOwner; p++ {
+		if strings.ToLower(arg) == p.String() {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
 // determine what to do in response to the provided arguments
 // bool return will be false for no match
 // e.g. cmd, args, ok := matchCommand(argv)
@@ -49,32 +84,107 @@ func matchCommand(available []command, arg string) (command, []string, bool) {
 	return command{}, nil, false
 }
 
-// determine a plugin that can handle the provided arguments
-// bool return will be false for no match
-func matchPlugin(plugins []plugins.Plugin, arg string) (serviceFunc, bool) {
-	if arg == "" {
-		return nil, false
+// findPlugin resolves arg with the first plugin that can handle it.
+// What it returns queues everything that was found.
+// space is how many more songs fit in the playlist, see queueSpace, which playlists are not looked through past.
+// Negative space does not limit playlists.
+func findPlugin(available []plugins.Plugin, arg string, space int) finder {
+	return func(ctx context.Context) (serviceFunc, error) {
+		if space == 0 {
+			return nil, ErrQueueFull
+		}
+		if space > 0 {
+			ctx = plugins.WithLimit(ctx, space)
+		}
+		for _, pl := range available {
+			if !pl.CanHandle(ctx, arg) {
+				continue
+			}
+			if pr, ok := pl.(plugins.PlaylistResolver); ok {
+				tracks, err := pr.ResolvePlaylist(ctx, arg)
+				if err == plugins.ErrNotHandled {
+					continue
+				}
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to resolve playlist")
+				}
+				return queueTracks(arg, tracks, space), nil
+			}
+			md, err := pl.Resolve(ctx, arg)
+			if err == plugins.ErrNotHandled {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to resolve openable stream")
+			}
+			return func(gsvc *GuildService, evt GuildEvent, _ []string) error {
+				return gsvc.player.Put(evt, gsvc.GuildConfig, arg, md)
+			}, nil
+		}
+		return nil, errors.New("nothing can play " + arg)
 	}
+}
 
-	for _, pl := range plugins {
-		if pl.CanHandle(arg) {
-			return runPlugin(pl, arg), true
+// resolveTrack resolves arg to one track with the first plugin that can handle it.
+func resolveTrack(ctx context.Context, available []plugins.Plugin, arg string) (plugins.Metadata, error) {
+	for _, pl := range available {
+		if !pl.CanHandle(ctx, arg) {
+			continue
+		}
+		md, err := pl.Resolve(ctx, arg)
+		if err == plugins.ErrNotHandled {
+			continue
+		}
+		if err != nil {
+			return md, errors.Wrap(err, "failed to resolve openable stream")
 		}
+		return md, nil
 	}
-
-	return nil, false
+	return plugins.Metadata{}, errors.New("nothing can play " + arg)
 }
 
-func runPlugin(plugin plugins.Plugin, arg string) serviceFunc {
+// queueTracks queues each track of a playlist until the playlist is full,
+// then replies with how many tracks were queued.
+// tracks were only looked up as far as space, see findPlugin.
+func queueTracks(arg string, tracks []plugins.Metadata, space int) serviceFunc {
 	return func(gsvc *GuildService, evt GuildEvent, _ []string) error {
-		gsvc.discord.MessageReactionAdd(evt.ChannelID, evt.MessageID, "🔎")
-		defer gsvc.discord.MessageReactionRemove(evt.ChannelID, evt.MessageID, "🔎", "@me")
+		if len(tracks) == 0 {
+			return errors.New("playlist has no playable tracks")
+		}
+		// e.g. a link to one track on a service that also has albums
+		if len(tracks) == 1 {
+			return gsvc.player.Put(evt, gsvc.GuildConfig, arg, tracks[0])
+		}
 
-		md, err := plugin.Resolve(arg)
-		if err != nil {
-			return errors.Wrap(err, "failed to resolve openable stream")
+		added := 0
+		var err error
+		for _, md := range tracks {
+			err = gsvc.player.Put(evt, gsvc.GuildConfig, md.URL, md)
+			if err == ErrQueueFull || err == ErrInvalidMusicChannel {
+				break
+			}
+			if err != nil {
+				log.Printf("skip %v %v", md.Title, err)
+				continue
+			}
+			added++
+		}
+		if added == 0 {
+			if err == nil {
+				return errors.New("failed to queue any tracks")
+			}
+			return errors.Wrap(err, "failed to queue any tracks")
 		}
-		return gsvc.player.Put(evt, gsvc.MusicChannel, md, gsvc.Loudness)
+
+		// there could be more tracks that were not looked up
+		if err == ErrQueueFull || (space > 0 && len(tracks) >= space) {
+			gsvc.discord.ChannelMessageSend(evt.ChannelID,
+				fmt.Sprintf("Queued %d tracks, the playlist is full.", added))
+			return nil
+		}
+		gsvc.discord.ChannelMessageSend(evt.ChannelID,
+			fmt.Sprintf("Queued %d of %d tracks, skipped %d.", added, len(tracks), len(tracks)-added))
+		return nil
 	}
 }
 
@@ -98,14 +208,21 @@ var reconnect = command{
 	long:            "Restart the music player.  This will empty the playlist.",
 	restrictChannel: true,
 	ack:             "🆗",
+	permission:      PermissionDJ,
 	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
 		gsvc.player.Close()
+		// the player leaves unfinished songs saved when it closes
+		if err := gsvc.store.PutQueue(gsvc.guildID, nil); err != nil {
+			log.Printf("failed to clear saved songs %v", err)
+		}
 		// idle in the music channel
 		gsvc.player = NewGuildPlayer(
 			gsvc.guildID,
 			gsvc.discord,
+			gsvc.store,
 			gsvc.MusicChannel,
 			commandShortcuts(gsvc.commands),
+			gsvc.audio,
 		)
 		return nil
 	},
@@ -114,11 +231,31 @@ var reconnect = command{
 var skip = command{
 	name:            "skip",
 	usage:           "skip",
-	long:            "Skip the currently playing song.",
+	long:            "Skip the currently playing song.\nIf vote skip is enabled (see `set voteskip`), skip instead counts as a vote from a listener in the music channel.",
 	restrictChannel: true,
 	shortcut:        "⏭",
 	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
-		gsvc.player.Skip()
+		play, ok := gsvc.player.NowPlaying()
+		if gsvc.VoteSkip <= 0 || evt.AuthorID == gsvc.guildOwnerID || (ok && evt.AuthorID == play.AuthorID) {
+			gsvc.player.Skip()
+			return nil
+		}
+
+		guild, err := gsvc.discord.State.Guild(gsvc.guildID)
+		if err != nil {
+			return err
+		}
+		voters := listeners(guild, gsvc.MusicChannel, gsvc.discord.State.User.ID)
+		if !contains(voters, evt.AuthorID) {
+			return errors.New("join the music channel to vote")
+		}
+		required := int(math.Ceil(gsvc.VoteSkip * float64(len(voters))))
+		if required < 1 {
+			required = 1
+		} else if required > len(voters) {
+			required = len(voters)
+		}
+		gsvc.player.VoteSkip(evt.AuthorID, required)
 		return nil
 	},
 }
@@ -130,6 +267,7 @@ var pause = command{
 	long:            "Pause/unpause the currently playing song.",
 	restrictChannel: true,
 	shortcut:        "⏯",
+	permission:      PermissionDJ,
 	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
 		gsvc.player.Pause()
 		return nil
@@ -143,12 +281,259 @@ var clear = command{
 	long:            "Clear the playlist.",
 	restrictChannel: true,
 	ack:             "🔘",
+	permission:      PermissionDJ,
 	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
 		gsvc.player.Clear()
 		return nil
 	},
 }
 
+var remove = command{
+	name:            "remove",
+	alias:           []string{"rm"},
+	usage:           "remove [position]",
+	long:            "Remove the song at a position in the playlist.",
+	restrictChannel: true,
+	ack:             "🔘",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		idx, err := queueIndex(args, 0)
+		if err != nil {
+			return err
+		}
+		return gsvc.player.Remove(idx)
+	},
+}
+
+var move = command{
+	name:            "move",
+	alias:           []string{"mv"},
+	usage:           "move [from position] [to position]",
+	long:            "Move a song to a different position in the playlist.",
+	restrictChannel: true,
+	ack:             "🔀",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		from, err := queueIndex(args, 0)
+		if err != nil {
+			return err
+		}
+		to, err := queueIndex(args, 1)
+		if err != nil {
+			return err
+		}
+		return gsvc.player.Move(from, to)
+	},
+}
+
+var jump = command{
+	name:            "jump",
+	alias:           []string{"j"},
+	usage:           "jump [position]",
+	long:            "Skip to the song at a position in the playlist.  Songs queued before it are removed.",
+	restrictChannel: true,
+	ack:             "⏭",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		idx, err := queueIndex(args, 0)
+		if err != nil {
+			return err
+		}
+		return gsvc.player.Jump(idx)
+	},
+}
+
+var seek = command{
+	name:            "seek",
+	usage:           "seek [mm:ss|+seconds|-seconds]",
+	long:            "Play the current song from a position, e.g. `seek 1:30`.\n`seek +30` and `seek -15` move forward or back from where the song is.",
+	restrictChannel: true,
+	ack:             "⏩",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) == 0 {
+			return errors.New("seek where?")
+		}
+		play, ok := gsvc.player.NowPlaying()
+		if !ok {
+			return errors.New("nothing playing")
+		}
+		arg := args[0]
+		relative := strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-")
+		if relative {
+			arg = arg[1:]
+		}
+		to, err := plugins.ParseTimestamp(arg)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(args[0], "-") {
+			to = play.Elapsed - to
+		} else if relative {
+			to = play.Elapsed + to
+		}
+		return gsvc.player.Seek(to)
+	},
+}
+
+var shuffle = command{
+	name:            "shuffle",
+	usage:           "shuffle",
+	long:            "Shuffle the playlist.",
+	restrictChannel: true,
+	ack:             "🔀",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		gsvc.player.Shuffle()
+		return nil
+	},
+}
+
+// queueIndex converts the position shown by the playlist command at args[n] to a queue index.
+func queueIndex(args []string, n int) (int, error) {
+	if len(args) <= n {
+		return 0, errors.New("position please")
+	}
+	pos, err := strconv.Atoi(args[n])
+	if err != nil {
+		return 0, errors.Errorf("%v is not a position", args[n])
+	}
+	return pos - 1, nil
+}
+
+var loop = command{
+	name:            "loop",
+	alias:           []string{"repeat"},
+	usage:           "loop [off|track|queue]",
+	long:            "Repeat the currently playing song or the whole playlist.\nOmit the mode to cycle through `off`, `track`, and `queue`.",
+	restrictChannel: true,
+	shortcut:        "🔁",
+	ack:             "🆗",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) == 0 {
+			gsvc.player.SetLoop((gsvc.player.Loop() + 1) % (LoopQueue + 1))
+			return nil
+		}
+		for mode := LoopOff; mode <= LoopQueue; mode++ {
+			if strings.ToLower(args[0]) == mode.String() {
+				gsvc.player.SetLoop(mode)
+				return nil
+			}
+		}
+		return errors.Errorf("%v is not a loop mode", args[0])
+	},
+}
+
+var volume = command{
+	name:            "volume",
+	alias:           []string{"vol"},
+	usage:           "volume [0-200]",
+	long:            fmt.Sprintf("Set how loud songs play, in percent, starting with the song that is playing.  Omit the volume to see it.\n%s and %s on the player turn it up and down.", louder.shortcut, quieter.shortcut),
+	restrictChannel: true,
+	ack:             "🆗",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) == 0 {
+			gsvc.discord.ChannelMessageSend(evt.ChannelID, fmt.Sprintf("%s %d%%", louder.shortcut, configVolume(gsvc.Volume)))
+			return nil
+		}
+		percent, err := strconv.Atoi(strings.TrimSuffix(args[0], "%"))
+		if err != nil || percent < 0 || percent > MaxVolume {
+			return errors.Errorf("volume is from 0 to %d", MaxVolume)
+		}
+		return gsvc.setVolume(percent)
+	},
+}
+
+var louder = command{
+	name:            "louder",
+	usage:           "louder [percent]",
+	long:            "Turn the volume up, by 10% unless you say otherwise.",
+	restrictChannel: true,
+	shortcut:        "🔊",
+	ack:             "🆗",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		step, err := volumeStep(args)
+		if err != nil {
+			return err
+		}
+		return gsvc.setVolume(configVolume(gsvc.Volume) + step)
+	},
+}
+
+var quieter = command{
+	name:            "quieter",
+	usage:           "quieter [percent]",
+	long:            "Turn the volume down, by 10% unless you say otherwise.",
+	restrictChannel: true,
+	shortcut:        "🔉",
+	ack:             "🆗",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		step, err := volumeStep(args)
+		if err != nil {
+			return err
+		}
+		return gsvc.setVolume(configVolume(gsvc.Volume) - step)
+	},
+}
+
+func volumeStep(args []string) (int, error) {
+	if len(args) == 0 {
+		return 10, nil
+	}
+	step, err := strconv.Atoi(strings.TrimSuffix(args[0], "%"))
+	if err != nil || step < 0 {
+		return 0, errors.Errorf("%v is not a percent", args[0])
+	}
+	return step, nil
+}
+
+// setVolume saves the guild's volume and changes the volume of the song that is playing.
+func (gsvc *GuildService) setVolume(percent int) error {
+	if percent < 0 {
+		percent = 0
+	} else if percent > MaxVolume {
+		percent = MaxVolume
+	}
+	gsvc.Volume = percent
+	// 0 would mean the default
+	if percent == 0 {
+		gsvc.Volume = -1
+	}
+	gsvc.player.SetVolume(percent)
+	return gsvc.store.Put(gsvc.guildID, gsvc.GuildConfig)
+}
+
+var fx = command{
+	name:            "fx",
+	alias:           []string{"effects"},
+	usage:           "fx [off|bassboost|nightcore|vaporwave|8d|speed [0.5-2]]",
+	long:            "Change how songs sound, starting from where the song that is playing is.  Name several effects to combine them, e.g. `fx bassboost speed 1.5`.\n`fx` shows the effects in use.  `fx off` turns them off.",
+	restrictChannel: true,
+	ack:             "🎛",
+	permission:      PermissionDJ,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) == 0 {
+			msg := "No effects."
+			if effects := gsvc.player.Effects(); len(effects) > 0 {
+				msg = "Using " + describeEffects(effects) + "."
+			}
+			msg += "\nAvailable " + strings.Join(effectNames(), ", ") + "."
+			gsvc.discord.ChannelMessageSend(evt.ChannelID, msg)
+			return nil
+		}
+		effects, err := parseEffects(args)
+		if err != nil {
+			return err
+		}
+		gsvc.player.SetEffects(effects)
+		return nil
+	},
+}
+
 var requeue = command{
 	name:            "requeue",
 	alias:           []string{"rq"},
@@ -162,7 +547,64 @@ var requeue = command{
 		if !ok {
 			return errors.New("nothing playing")
 		}
-		return gsvc.player.Put(evt, gsvc.MusicChannel, play.Metadata, gsvc.Loudness)
+		return gsvc.player.Put(evt, gsvc.GuildConfig, play.Query, play.Metadata)
+	},
+}
+
+var history = command{
+	name:            "history",
+	alias:           []string{"hist"},
+	usage:           "history [count]",
+	long:            fmt.Sprintf("List recently played songs, most recent first.  Remembers up to %d songs.", historyLength),
+	restrictChannel: true,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		n := 10
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				return errors.Errorf("%v is not a count", args[0])
+			}
+		}
+		played, err := gsvc.store.History(gsvc.guildID, n)
+		if err != nil {
+			return err
+		}
+		lines := make([]string, len(played))
+		for i, song := range played {
+			lines[i] = fmt.Sprintf("%s [%s/%s] %s",
+				song.Title, prettyTime(song.Elapsed), prettyTime(song.Duration), gsvc.displayName(song.AuthorID))
+		}
+		historyString := strings.Join(numbered(lines), "\n")
+		gsvc.discord.ChannelMessageSend(evt.ChannelID, "```\n"+historyString+"\n```")
+		return nil
+	},
+}
+
+var previous = command{
+	name:            "previous",
+	alias:           []string{"prev"},
+	usage:           "previous",
+	long:            "Queue the most recently played song to play next.",
+	restrictChannel: true,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		played, err := gsvc.store.History(gsvc.guildID, 1)
+		if err != nil {
+			return err
+		}
+		if len(played) == 0 || played[0].Query == "" {
+			return errors.New("nothing played")
+		}
+		query, available := played[0].Query, gsvc.plugins()
+		gsvc.lookup(evt, requeue.ack, func(ctx context.Context) (serviceFunc, error) {
+			md, err := resolveTrack(ctx, available, query)
+			if err != nil {
+				return nil, err
+			}
+			return func(gsvc *GuildService, evt GuildEvent, _ []string) error {
+				return gsvc.player.PutFront(evt, gsvc.GuildConfig, query, md)
+			}, nil
+		})
+		return nil
 	},
 }
 
@@ -173,12 +615,21 @@ var playlist = command{
 	long:            "List any queued songs.",
 	restrictChannel: true,
 	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
-		playlistString := strings.Join(gsvc.player.Playlist(), "\n")
+		playlistString := strings.Join(numbered(gsvc.player.Playlist()), "\n")
 		gsvc.discord.ChannelMessageSend(evt.ChannelID, "```\n"+playlistString+"\n```")
 		return nil
 	},
 }
 
+// numbered prefixes each line with its position, as understood by commands like remove and move
+func numbered(lst []string) []string {
+	lines := make([]string, len(lst))
+	for i, v := range lst {
+		lines[i] = fmt.Sprintf("%d. %s", i+1, v)
+	}
+	return lines
+}
+
 var get = command{
 	name:  "get",
 	usage: "get [field]",
@@ -222,9 +673,10 @@ var get = command{
 // omit value to zero the field
 // deferred call to get.run serves as ack
 var set = command{
-	name:  "set",
-	usage: "set [field] [value]",
-	long:  "Set preferences for this guild.  Omit [value] to empty the preference.",
+	name:       "set",
+	usage:      "set [field] [value]",
+	long:       "Set preferences for this guild.  Omit [value] to empty the preference.",
+	permission: PermissionOwner,
 	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
 		if len(args) == 0 {
 			return errors.New("field please")
@@ -237,7 +689,7 @@ var set = command{
 				if len(args) == 1 {
 					return fld.Zero()
 				}
-				val, err := resolveValue(fld.Kind(), args[1])
+				val, err := resolveValue(fld.Value(), args[1])
 				if err != nil {
 					return err
 				}
@@ -248,8 +700,11 @@ var set = command{
 	},
 }
 
-func resolveValue(kind reflect.Kind, arg string) (val interface{}, err error) {
-	switch kind {
+func resolveValue(current interface{}, arg string) (val interface{}, err error) {
+	if _, ok := current.(time.Duration); ok {
+		return time.ParseDuration(arg)
+	}
+	switch reflect.TypeOf(current).Kind() {
 	case reflect.String:
 		val = arg
 	case reflect.Bool:
@@ -270,7 +725,8 @@ var setPlayback = command{
 	long: "Set the music playback channel for the guild." +
 		"\n`playback` or `playback detect` will look for a voice channel starting with `" + DefaultMusicChannelPrefix + "`." +
 		"\n`playback here` will look for the voice channel you are in.",
-	ack: "🆗",
+	ack:        "🆗",
+	permission: PermissionOwner,
 	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
 		guild, err := gsvc.discord.State.Guild(gsvc.guildID)
 		if err != nil {
@@ -296,9 +752,10 @@ var setPlayback = command{
 }
 
 var setListen = command{
-	name:  "whitelist",
-	usage: "whitelist",
-	ack:   "🆗",
+	name:       "whitelist",
+	usage:      "whitelist",
+	ack:        "🆗",
+	permission: PermissionOwner,
 	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
 		textChannelID := evt.ChannelID
 		if textChannelID == "" {
@@ -312,9 +769,10 @@ var setListen = command{
 }
 
 var unsetListen = command{
-	name:  "unwhitelist",
-	usage: "unwhitelist",
-	ack:   "🆗",
+	name:       "unwhitelist",
+	usage:      "unwhitelist",
+	ack:        "🆗",
+	permission: PermissionOwner,
 	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
 		textChannelID := evt.ChannelID
 		if textChannelID == "" {
@@ -329,6 +787,239 @@ var unsetListen = command{
 	},
 }
 
+var setDJ = command{
+	name:       "dj",
+	usage:      "dj [add|remove] [role]",
+	long:       "Add or remove a DJ role for the guild.  Some commands will only run for members with a DJ role.\nIf the guild has no DJ roles, those commands run for everyone.",
+	permission: PermissionOwner,
+	ack:        "🆗",
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) < 2 {
+			return errors.New("add or remove a role please")
+		}
+		guild, err := gsvc.discord.State.Guild(gsvc.guildID)
+		if err != nil {
+			return err
+		}
+		roleID := findRole(guild, strings.Join(args[1:], " "))
+		if roleID == "" {
+			return errors.New("role not found")
+		}
+		switch strings.ToLower(args[0]) {
+		case "add":
+			if !contains(gsvc.DJRoles, roleID) {
+				gsvc.DJRoles = append(gsvc.DJRoles, roleID)
+			}
+		case "remove":
+			for i, r := range gsvc.DJRoles {
+				if r == roleID {
+					gsvc.DJRoles = append(gsvc.DJRoles[:i], gsvc.DJRoles[i+1:]...)
+					break
+				}
+			}
+		default:
+			return errors.New("add or remove a role please")
+		}
+		return gsvc.store.Put(gsvc.guildID, gsvc.GuildConfig)
+	},
+}
+
+// findRole looks for a role by mention, id, or name
+func findRole(g *discordgo.Guild, arg string) string {
+	arg = strings.TrimSuffix(strings.TrimPrefix(arg, "<@&"), ">")
+	for _, role := range g.Roles {
+		if role.ID == arg || strings.EqualFold(role.Name, arg) {
+			return role.ID
+		}
+	}
+	return ""
+}
+
+var setPermission = command{
+	name:       "permission",
+	usage:      "permission [command name] [everyone|dj|owner]",
+	long:       "Change who can run a command in this guild.  Omit the level to restore the default.",
+	permission: PermissionOwner,
+	ack:        "🆗",
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) == 0 {
+			return errors.New("command please")
+		}
+		cmd, ok := commandByNameOrAlias(gsvc.commands, strings.ToLower(args[0]))
+		if !ok {
+			return errors.New("command not found")
+		}
+		if cmd.name == "permission" {
+			return errors.New("permission is always for the owner")
+		}
+		if len(args) == 1 {
+			delete(gsvc.Permissions, cmd.name)
+			return gsvc.store.Put(gsvc.guildID, gsvc.GuildConfig)
+		}
+		level, ok := parsePermission(args[1])
+		if !ok {
+			return errors.Errorf("%v is not a permission level", args[1])
+		}
+		if gsvc.Permissions == nil {
+			gsvc.Permissions = make(map[string]Permission)
+		}
+		gsvc.Permissions[cmd.name] = level
+		return gsvc.store.Put(gsvc.guildID, gsvc.GuildConfig)
+	},
+}
+
+var library = command{
+	name:       "library",
+	alias:      []string{"lib"},
+	usage:      "library rescan",
+	long:       "Look for new, changed, or deleted files in the bot's local music library.  Play from the library with lib:[search terms].",
+	permission: PermissionOwner,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) == 0 || strings.ToLower(args[0]) != "rescan" {
+			return errors.New("rescan please")
+		}
+		// even if this guild does not use the library, others might
+		var lib *plugins.Library
+		for _, p := range plugins.Plugins(gsvc.sources) {
+			// the library is wrapped if its section sets a cachettl
+			if c, ok := p.(*plugins.Cache); ok {
+				p = c.Plugin
+			}
+			if l, ok := p.(*plugins.Library); ok {
+				lib = l
+				break
+			}
+		}
+		if lib == nil {
+			return errors.New("no library is configured")
+		}
+		n, err := lib.Rescan()
+		if err != nil {
+			return errors.Wrap(err, "failed to scan library")
+		}
+		gsvc.discord.ChannelMessageSend(evt.ChannelID, fmt.Sprintf("Found %d tracks in the library.", n))
+		return nil
+	},
+}
+
+var cache = command{
+	name:  "cache",
+	usage: "cache [stats|purge]",
+	long: "`cache stats` shows how often the bot remembered what it already looked up or downloaded, instead of asking a source again." +
+		"\n`cache purge` deletes the downloaded audio.",
+	permission: PermissionOwner,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) == 0 {
+			return errors.New("stats or purge please")
+		}
+		// every guild shares the caches
+		switch strings.ToLower(args[0]) {
+		case "stats":
+			var lines []string
+			for _, p := range plugins.Plugins(gsvc.sources) {
+				if c, ok := p.(*plugins.Cache); ok {
+					hits, misses := c.Stats()
+					lines = append(lines, fmt.Sprintf("`%s` %d hits, %d misses", c.Name, hits, misses))
+				}
+			}
+			if gsvc.audio != nil {
+				hits, misses, tracks, size := gsvc.audio.Stats()
+				lines = append(lines, fmt.Sprintf("`audio` %d hits, %d misses, %d tracks using %s of %s",
+					hits, misses, tracks, prettyBytes(size), prettyBytes(gsvc.audio.MaxSize())))
+			}
+			if len(lines) == 0 {
+				return errors.New("nothing is cached")
+			}
+			gsvc.discord.ChannelMessageSend(evt.ChannelID, strings.Join(lines, "\n"))
+			return nil
+		case "purge":
+			if gsvc.audio == nil {
+				return errors.New("no audio cache is configured")
+			}
+			n, err := gsvc.audio.Purge()
+			if err != nil {
+				return errors.Wrap(err, "failed to purge audio cache")
+			}
+			gsvc.discord.ChannelMessageSend(evt.ChannelID, fmt.Sprintf("Deleted %d tracks from the audio cache.", n))
+			return nil
+		}
+		return errors.Errorf("%v is not stats or purge", args[0])
+	},
+}
+
+func prettyBytes(n int64) string {
+	if n >= 1<<30 {
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
+
+var setSources = command{
+	name:  "sources",
+	usage: "sources [use|enable|disable|reset] [source names]",
+	long: "Choose the sources of music to try, in order.\n`sources` lists the sources this guild uses." +
+		"\n`sources use [names]` tries only the named sources, in that order." +
+		"\n`sources enable|disable [names]` allows or bans sources.\n`sources reset` uses every source, in the bot's order.",
+	permission: PermissionOwner,
+	ack:        "🆗",
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) == 0 {
+			msg := fmt.Sprintf("Using %s.\nAvailable %s.",
+				strings.Join(sourceNames(gsvc.enabledSources()), ", "),
+				strings.Join(sourceNames(gsvc.sources), ", "))
+			gsvc.discord.ChannelMessageSend(evt.ChannelID, msg)
+			return nil
+		}
+		names := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			name := strings.ToLower(arg)
+			if !contains(sourceNames(gsvc.sources), name) {
+				return errors.Errorf("%v is not a source", arg)
+			}
+			names = append(names, name)
+		}
+		switch strings.ToLower(args[0]) {
+		case "use":
+			if len(names) == 0 {
+				return errors.New("sources please")
+			}
+			gsvc.Sources = names
+		case "enable":
+			for _, name := range names {
+				for i, disabled := range gsvc.DisabledSources {
+					if disabled == name {
+						gsvc.DisabledSources = append(gsvc.DisabledSources[:i], gsvc.DisabledSources[i+1:]...)
+						break
+					}
+				}
+				if len(gsvc.Sources) > 0 && !contains(gsvc.Sources, name) {
+					gsvc.Sources = append(gsvc.Sources, name)
+				}
+			}
+		case "disable":
+			for _, name := range names {
+				if !contains(gsvc.DisabledSources, name) {
+					gsvc.DisabledSources = append(gsvc.DisabledSources, name)
+				}
+			}
+		case "reset":
+			gsvc.Sources = nil
+			gsvc.DisabledSources = nil
+		default:
+			return errors.New("use, enable, disable, or reset please")
+		}
+		return gsvc.store.Put(gsvc.guildID, gsvc.GuildConfig)
+	},
+}
+
+func sourceNames(sources []plugins.Source) []string {
+	names := make([]string, len(sources))
+	for i, src := range sources {
+		names[i] = src.Name
+	}
+	return names
+}
+
 var help = command{
 	name:     "help",
 	alias:    []string{"h"},
@@ -356,7 +1047,7 @@ var help = command{
 			}
 		}
 
-		embed := helpForCommandList(gsvc.commands)
+		embed := helpForCommandList(gsvc.commands, sourceNames(gsvc.enabledSources()))
 		_, err = gsvc.discord.ChannelMessageSendEmbed(dmChannelID, embed)
 		return err
 	},
@@ -383,9 +1074,19 @@ func helpForCommand(cmd command) *discordgo.MessageEmbed {
 			Value: fmt.Sprintf("`%s`", strings.Join(cmd.alias, "`, `")),
 		})
 	}
+	var footer []string
 	if cmd.restrictChannel {
+		footer = append(footer, "This command will only run in whitelisted channels (see whitelist).")
+	}
+	switch cmd.permission {
+	case PermissionDJ:
+		footer = append(footer, "This command needs a DJ role, if the guild has any (see dj).")
+	case PermissionOwner:
+		footer = append(footer, "This command will only run for the owner of the guild.")
+	}
+	if len(footer) > 0 {
 		embed.Footer = &discordgo.MessageEmbedFooter{
-			Text: "This command will only run in whitelisted channels (see whitelist).",
+			Text: strings.Join(footer, "\n"),
 		}
 	}
 	return embed
@@ -396,15 +1097,14 @@ var helpDesc = fmt.Sprintf(
 	DefaultCommandPrefix, DefaultCommandPrefix, DefaultCommandPrefix,
 )
 
-// TODO update help instructions to reflect supported plugins
-func helpForCommandList(commands []command) *discordgo.MessageEmbed {
+func helpForCommandList(commands []command, sources []string) *discordgo.MessageEmbed {
 	embed := &discordgo.MessageEmbed{}
 	embed.Title = "help"
 	embed.Description = helpDesc
 	buf := &bytes.Buffer{}
 	w := tabwriter.NewWriter(buf, 4, 4, 0, '.', 0)
 	for _, cmd := range commands {
-		if !cmd.ownerOnly {
+		if cmd.permission != PermissionOwner {
 			aliasList := ""
 			if len(cmd.alias) > 0 {
 				aliasList = "`" + strings.Join(cmd.alias, "`, `") + "`"
@@ -423,6 +1123,12 @@ func helpForCommandList(commands []command) *discordgo.MessageEmbed {
 			Value: buf.String(),
 		},
 	}
+	if len(sources) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Sources",
+			Value: strings.Join(sources, ", "),
+		})
+	}
 	embed.Footer = &discordgo.MessageEmbedFooter{
 		Text: "Commands with a * will only run in whitelisted channels.",
 	}
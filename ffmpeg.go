@@ -0,0 +1,300 @@
+package musicbot
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// decodeStream's output format
+const (
+	pcmSampleRate     = 48000
+	pcmChannels       = 2
+	pcmFrameSize      = 2 * pcmChannels
+	pcmBytesPerSecond = pcmSampleRate * pcmFrameSize
+)
+
+// decodeStream decodes the audio in in to 16 bit pcm in a wav container, so that its volume can change while it plays.
+// The audio starts from offset, and goes through filters, an ffmpeg filter chain, on the way.
+func decodeStream(ctx context.Context, in io.ReadCloser, offset time.Duration, filters []string) (io.ReadCloser, error) {
+	ffmpeg := exec.CommandContext(ctx, "ffmpeg", decodeArgs(offset, filters)...)
+	ffmpeg.Stdin = in
+	stdout, err := ffmpeg.StdoutPipe()
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	if err := ffmpeg.Start(); err != nil {
+		in.Close()
+		return nil, err
+	}
+	return ffmpegReadCloser{stdout, ffmpeg, in}, nil
+}
+
+// decodeArgs are the arguments to ffmpeg for decodeStream.
+func decodeArgs(offset time.Duration, filters []string) []string {
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", "pipe:0",
+	}
+	if offset > 0 {
+		// trimmed first so that offset is in the song's own time, not the time of filters that change the tempo,
+		// most streams cannot seek so ffmpeg reads up to offset and throws it away either way
+		trim := []string{fmt.Sprintf("atrim=start=%.3f", offset.Seconds()), "asetpts=PTS-STARTPTS"}
+		filters = append(trim, filters...)
+	}
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+	return append(args,
+		"-vn",
+		// no metadata chunks between the format and the samples
+		"-map_metadata", "-1",
+		"-bitexact",
+		"-c:a", "pcm_s16le",
+		"-ar", strconv.Itoa(pcmSampleRate),
+		"-ac", strconv.Itoa(pcmChannels),
+		"-f", "wav",
+		"pipe:1",
+	)
+}
+
+type ffmpegReadCloser struct {
+	io.Reader
+	ffmpeg *exec.Cmd
+	in     io.Closer
+}
+
+// the input is closed first, otherwise Wait could be stuck copying from a stalled input
+func (frc ffmpegReadCloser) Close() error {
+	frc.in.Close()
+	frc.ffmpeg.Process.Kill()
+	err := frc.ffmpeg.Wait()
+	log.Printf("closed ffmpeg %v", err)
+	return err
+}
+
+// loudnormFilter targets a loudness, see GuildConfig.Loudness.
+// Returns "" for loudness outside of what loudnorm allows.
+func loudnormFilter(loudness float64) string {
+	if loudness < -70 || loudness > -5 {
+		return ""
+	}
+	return fmt.Sprintf("loudnorm=i=%.1f", loudness)
+}
+
+// pcmReader reads a 16 bit pcm wav stream from decodeStream.
+// It changes the volume of the samples as they are read, and can fade into the next song's stream at the end.
+type pcmReader struct {
+	io.ReadCloser
+	// volume in percent, which can change while the stream is read
+	volume *int32
+
+	mu sync.Mutex
+	// header is the part of the wav container before the samples that has not been read yet
+	header []byte
+	// the header has been found
+	started bool
+	// bytes of samples read so far, counting samples mixed into another stream
+	read int64
+
+	// transition runs once the stream reaches transitionAt
+	transitionAt int64
+	transition   func()
+	// from fadeAt, the stream fades out over fadeLength while the stream returned by next fades in
+	fadeAt     int64
+	fadeLength int64
+	next       func() *pcmReader
+	fading     *pcmReader
+	triedNext  bool
+}
+
+func newPCMReader(rc io.ReadCloser, volume *int32) *pcmReader {
+	return &pcmReader{ReadCloser: rc, volume: volume}
+}
+
+// onTransition runs fn, in its own goroutine, once there is before left of a stream that is length long,
+// then fades into next over fade.
+func (pr *pcmReader) onTransition(length time.Duration, before time.Duration, fn func(), fade time.Duration, next func() *pcmReader) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.transitionAt = pcmBytes(length - before)
+	pr.transition = fn
+	// leave some of the stream to fade from
+	if fade > length/2 {
+		fade = length / 2
+	}
+	if fade > 0 {
+		pr.fadeAt, pr.fadeLength = pcmBytes(length-fade), pcmBytes(fade)
+		pr.next = next
+	}
+}
+
+// position is how much of the stream has been read.
+func (pr *pcmReader) position() time.Duration {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return time.Duration(pr.read) * time.Second / pcmBytesPerSecond
+}
+
+func (pr *pcmReader) Read(p []byte) (int, error) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if err := pr.start(); err != nil {
+		return 0, err
+	}
+	if len(pr.header) > 0 {
+		n := copy(p, pr.header)
+		pr.header = pr.header[n:]
+		return n, nil
+	}
+
+	from := pr.read
+	n, err := pr.samples(p)
+	if pr.transition != nil && pr.read >= pr.transitionAt {
+		go pr.transition()
+		pr.transition = nil
+	}
+	if pr.fadeLength > 0 && pr.read > pr.fadeAt {
+		pr.fade(p[:n], from)
+	}
+
+	volume := atomic.LoadInt32(pr.volume)
+	if volume == 100 {
+		return n, err
+	}
+	gain := float64(volume) / 100
+	for i := 0; i+1 < n; i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(p[i:]))) * gain
+		binary.LittleEndian.PutUint16(p[i:], uint16(clampSample(sample)))
+	}
+	return n, err
+}
+
+func (pr *pcmReader) start() error {
+	if pr.started {
+		return nil
+	}
+	header, err := readWavHeader(pr.ReadCloser)
+	if err != nil {
+		return err
+	}
+	pr.header, pr.started = header, true
+	return nil
+}
+
+// samples reads whole frames of samples into p.
+func (pr *pcmReader) samples(p []byte) (int, error) {
+	if len(p) < pcmFrameSize {
+		return 0, io.ErrShortBuffer
+	}
+	n, err := pr.ReadCloser.Read(p[:len(p)-len(p)%pcmFrameSize])
+	if partial := n % pcmFrameSize; partial > 0 {
+		if _, err := io.ReadFull(pr.ReadCloser, p[n:n+pcmFrameSize-partial]); err == nil {
+			n += pcmFrameSize - partial
+		} else {
+			n -= partial
+		}
+	}
+	pr.read += int64(n)
+	return n, err
+}
+
+// fade mixes the next stream into p, which starts at from.
+func (pr *pcmReader) fade(p []byte, from int64) {
+	if !pr.triedNext {
+		pr.triedNext = true
+		pr.fading = pr.next()
+	}
+	if pr.fading == nil {
+		return
+	}
+
+	pr.fading.mu.Lock()
+	defer pr.fading.mu.Unlock()
+	skip := 0
+	if from < pr.fadeAt {
+		skip = int(pr.fadeAt - from)
+	}
+	if skip >= len(p) {
+		return
+	}
+	if err := pr.fading.start(); err != nil {
+		pr.fading = nil
+		return
+	}
+	next := make([]byte, len(p)-skip)
+	n, err := io.ReadFull(readerFunc(pr.fading.samples), next)
+	for i := 0; i+1 < n; i += 2 {
+		t := math.Min(1, float64(from+int64(skip+i)-pr.fadeAt)/float64(pr.fadeLength))
+		a := float64(int16(binary.LittleEndian.Uint16(p[skip+i:])))
+		b := float64(int16(binary.LittleEndian.Uint16(next[i:])))
+		binary.LittleEndian.PutUint16(p[skip+i:], uint16(clampSample(a*(1-t)+b*t)))
+	}
+	if err != nil {
+		pr.fading = nil
+	}
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (fn readerFunc) Read(p []byte) (int, error) {
+	return fn(p)
+}
+
+func clampSample(sample float64) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, sample)))
+}
+
+// pcmBytes is how many bytes of samples play for d.
+func pcmBytes(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	n := int64(d.Seconds() * pcmBytesPerSecond)
+	return n - n%pcmFrameSize
+}
+
+// readWavHeader reads everything in a wav container up to the first sample.
+func readWavHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, errors.New("not a wav stream")
+	}
+	for {
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+		header = append(header, chunk...)
+		if string(chunk[:4]) == "data" {
+			return header, nil
+		}
+		// chunks are padded to an even size
+		size := binary.LittleEndian.Uint32(chunk[4:])
+		size += size % 2
+		if size > 1<<16 {
+			return nil, errors.Errorf("wav chunk %q is too big", chunk[:4])
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		header = append(header, body...)
+	}
+}
@@ -0,0 +1,246 @@
+package musicbot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// wavStream is the samples in a wav container like the one decodeStream writes,
+// with an odd sized chunk before the samples if extra is true.
+func wavStream(samples []int16, extra bool) (stream []byte, headerLength int) {
+	var buf bytes.Buffer
+	chunk := func(id string, body []byte) {
+		buf.WriteString(id)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(body)))
+		buf.Write(body)
+		if len(body)%2 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(0xFFFFFFFF))
+	buf.WriteString("WAVE")
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:], 1)
+	binary.LittleEndian.PutUint16(format[2:], pcmChannels)
+	binary.LittleEndian.PutUint32(format[4:], pcmSampleRate)
+	binary.LittleEndian.PutUint32(format[8:], pcmBytesPerSecond)
+	binary.LittleEndian.PutUint16(format[12:], pcmFrameSize)
+	binary.LittleEndian.PutUint16(format[14:], 16)
+	chunk("fmt ", format)
+	if extra {
+		chunk("LIST", []byte("odd"))
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(0xFFFFFFFF))
+	headerLength = buf.Len()
+	binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes(), headerLength
+}
+
+// constantSamples is d of both channels at value.
+func constantSamples(value int16, d time.Duration) []int16 {
+	samples := make([]int16, pcmBytes(d)/2)
+	for i := range samples {
+		samples[i] = value
+	}
+	return samples
+}
+
+// readPCM reads all of pr size bytes at a time, and returns the samples after the header.
+func readPCM(t *testing.T, pr *pcmReader, size int, headerLength int) []int16 {
+	var out []byte
+	p := make([]byte, size)
+	for {
+		n, err := pr.Read(p)
+		out = append(out, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read failed %v", err)
+		}
+	}
+	if len(out) < headerLength || (len(out)-headerLength)%pcmFrameSize != 0 {
+		t.Fatalf("read %d bytes, which is not the header and whole frames", len(out))
+	}
+	samples := make([]int16, (len(out)-headerLength)/2)
+	binary.Read(bytes.NewReader(out[headerLength:]), binary.LittleEndian, samples)
+	return samples
+}
+
+func TestReadWavHeader(t *testing.T) {
+	for _, extra := range []bool{false, true} {
+		stream, headerLength := wavStream([]int16{1, 2}, extra)
+		r := bytes.NewReader(stream)
+		header, err := readWavHeader(r)
+		if err != nil {
+			t.Fatalf("readWavHeader failed %v", err)
+		}
+		if !bytes.Equal(header, stream[:headerLength]) {
+			t.Errorf("readWavHeader = %q, want %q", header, stream[:headerLength])
+		}
+		if rest, _ := ioutil.ReadAll(r); !bytes.Equal(rest, stream[headerLength:]) {
+			t.Errorf("readWavHeader left %v, want the samples %v", rest, stream[headerLength:])
+		}
+	}
+
+	if _, err := readWavHeader(bytes.NewReader([]byte("ID3 not a wav file"))); err == nil {
+		t.Error("readWavHeader of an mp3 did not fail")
+	}
+	big := []byte("RIFF\xff\xff\xff\xffWAVEjunk\xff\xff\xff\x00")
+	if _, err := readWavHeader(bytes.NewReader(big)); err == nil {
+		t.Error("readWavHeader of a huge chunk did not fail")
+	}
+}
+
+func TestPCMReaderVolume(t *testing.T) {
+	samples := []int16{0, 100, -100, 20000, -20000, math.MaxInt16, math.MinInt16, 1}
+	tests := []struct {
+		volume int32
+		want   []int16
+	}{
+		{100, samples},
+		{50, []int16{0, 50, -50, 10000, -10000, 16383, -16384, 0}},
+		{0, []int16{0, 0, 0, 0, 0, 0, 0, 0}},
+		// clipped rather than wrapped around
+		{200, []int16{0, 200, -200, math.MaxInt16, math.MinInt16, math.MaxInt16, math.MinInt16, 2}},
+	}
+	for _, test := range tests {
+		stream, headerLength := wavStream(samples, true)
+		volume := test.volume
+		pr := newPCMReader(ioutil.NopCloser(bytes.NewReader(stream)), &volume)
+		got := readPCM(t, pr, 64, headerLength)
+		if len(got) != len(test.want) {
+			t.Fatalf("volume %d read %v, want %v", test.volume, got, test.want)
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("volume %d read %v, want %v", test.volume, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+// samples are never split between reads even if the stream arrives a byte at a time,
+// otherwise the volume would be applied to half a sample
+func TestPCMReaderAlignment(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(i * 31)
+	}
+	stream, headerLength := wavStream(samples, false)
+	for _, size := range []int{4, 6, 7, 13, 4096} {
+		volume := int32(50)
+		pr := newPCMReader(ioutil.NopCloser(iotest.OneByteReader(bytes.NewReader(stream))), &volume)
+		got := readPCM(t, pr, size, headerLength)
+		if len(got) != len(samples) {
+			t.Fatalf("buffer of %d read %d samples, want %d", size, len(got), len(samples))
+		}
+		for i := range got {
+			if want := samples[i] / 2; got[i] != want {
+				t.Fatalf("buffer of %d read sample %d = %d, want %d", size, i, got[i], want)
+			}
+		}
+		if pr.read%pcmFrameSize != 0 {
+			t.Errorf("buffer of %d read %d bytes of samples, which is not whole frames", size, pr.read)
+		}
+	}
+
+	// there is no room for a whole frame
+	stream, _ = wavStream(samples, false)
+	volume := int32(100)
+	pr := newPCMReader(ioutil.NopCloser(bytes.NewReader(stream)), &volume)
+	pr.Read(make([]byte, len(stream)))
+	if _, err := pr.Read(make([]byte, pcmFrameSize-1)); err != io.ErrShortBuffer {
+		t.Errorf("Read of less than a frame err = %v, want io.ErrShortBuffer", err)
+	}
+}
+
+func TestPCMReaderCrossfade(t *testing.T) {
+	const (
+		length = 100 * time.Millisecond
+		before = 50 * time.Millisecond
+		fade   = 20 * time.Millisecond
+	)
+	volume := int32(100)
+	stream, headerLength := wavStream(constantSamples(1000, length), false)
+	pr := newPCMReader(ioutil.NopCloser(bytes.NewReader(stream)), &volume)
+	nextStream, _ := wavStream(constantSamples(3000, length), true)
+	next := newPCMReader(ioutil.NopCloser(bytes.NewReader(nextStream)), &volume)
+
+	transitioned := make(chan bool, 1)
+	pr.onTransition(length, before, func() {
+		transitioned <- true
+	}, fade, func() *pcmReader {
+		return next
+	})
+	got := readPCM(t, pr, 1000, headerLength)
+
+	select {
+	case <-transitioned:
+	case <-time.After(time.Second):
+		t.Error("transition did not run")
+	}
+
+	fadeAt, fadeLength := pcmBytes(length-fade), pcmBytes(fade)
+	for i, sample := range got {
+		at := int64(i * 2)
+		want := 1000.0
+		if at >= fadeAt {
+			f := float64(at-fadeAt) / float64(fadeLength)
+			want = 1000*(1-f) + 3000*f
+		}
+		if math.Abs(float64(sample)-want) > 1 {
+			t.Fatalf("sample at %d = %d, want %.0f", at, sample, want)
+		}
+	}
+
+	// the next stream picks up after what was mixed in, with its header still to be read
+	if next.read != fadeLength {
+		t.Errorf("mixed in %d bytes of the next stream, want %d", next.read, fadeLength)
+	}
+	if len(next.header) == 0 {
+		t.Error("the next stream's header was read as part of the mix")
+	}
+}
+
+func TestDecodeArgs(t *testing.T) {
+	nightcore := effectPresets["nightcore"]
+	tests := []struct {
+		name    string
+		offset  time.Duration
+		filters []string
+		want    string
+	}{
+		{"nothing", 0, nil, ""},
+		{"loudnorm", 0, []string{"loudnorm=i=-16.0"}, "loudnorm=i=-16.0"},
+		// the offset is in the song's time, so it is trimmed before the tempo changes
+		{"speed", 90 * time.Second, speedEffect(1.5).filters,
+			"atrim=start=90.000,asetpts=PTS-STARTPTS,atempo=1.500"},
+		{"nightcore", 1500 * time.Millisecond, append([]string{"loudnorm=i=-16.0"}, nightcore.filters...),
+			"atrim=start=1.500,asetpts=PTS-STARTPTS,loudnorm=i=-16.0,aresample=48000,asetrate=60000,aresample=48000"},
+	}
+	for _, test := range tests {
+		args := decodeArgs(test.offset, test.filters)
+		var filters string
+		for i, arg := range args {
+			if arg == "-ss" {
+				t.Errorf("%v seeks with -ss, which is after the filters change the tempo", test.name)
+			}
+			if arg == "-af" && i+1 < len(args) {
+				filters = args[i+1]
+			}
+		}
+		if filters != test.want {
+			t.Errorf("%v filters = %q, want %q", test.name, filters, test.want)
+		}
+	}
+}
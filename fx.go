@@ -0,0 +1,116 @@
+package musicbot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Effect changes how songs sound.
+type Effect struct {
+	Name string
+	// filters are an ffmpeg filter chain.
+	// They are only ever written here, never taken from a user.
+	filters []string
+	// Tempo is how much faster than normal the effect plays songs.
+	Tempo float64
+}
+
+// effectPresets are the only effects a guild can use.
+// Pitch shifts resample at a different rate, which also changes the tempo.
+var effectPresets = map[string]Effect{
+	"bassboost": {Name: "bassboost", filters: []string{"bass=g=10"}, Tempo: 1},
+	"nightcore": {Name: "nightcore", filters: []string{"aresample=48000", "asetrate=60000", "aresample=48000"}, Tempo: 1.25},
+	"vaporwave": {Name: "vaporwave", filters: []string{"aresample=48000", "asetrate=38400", "aresample=48000"}, Tempo: 0.8},
+	"8d":        {Name: "8d", filters: []string{"apulsator=hz=0.125"}, Tempo: 1},
+}
+
+// speed changes tempo without changing pitch, within what ffmpeg's atempo allows.
+const (
+	defaultSpeed = 1.25
+	minSpeed     = 0.5
+	maxSpeed     = 2.0
+)
+
+func speedEffect(speed float64) Effect {
+	return Effect{
+		Name:    fmt.Sprintf("speed %gx", speed),
+		filters: []string{fmt.Sprintf("atempo=%.3f", speed)},
+		Tempo:   speed,
+	}
+}
+
+// parseEffects reads effects by name, e.g. "bassboost nightcore" or "speed 1.5x".
+// "off" is no effects.
+func parseEffects(args []string) ([]Effect, error) {
+	var effects []Effect
+	for i := 0; i < len(args); i++ {
+		name := strings.ToLower(args[i])
+		if name == "off" || name == "none" {
+			return nil, nil
+		}
+		if name == "speed" {
+			speed := defaultSpeed
+			if i+1 < len(args) {
+				if s, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(args[i+1]), "x"), 64); err == nil {
+					speed = s
+					i++
+				}
+			}
+			// written so that NaN is out of range too
+			if !(speed >= minSpeed && speed <= maxSpeed) {
+				return nil, errors.Errorf("speed is from %gx to %gx", minSpeed, maxSpeed)
+			}
+			effects = append(effects, speedEffect(speed))
+			continue
+		}
+		effect, ok := effectPresets[name]
+		if !ok {
+			return nil, errors.Errorf("%v is not an effect, try %s", args[i], strings.Join(effectNames(), ", "))
+		}
+		effects = append(effects, effect)
+	}
+	return effects, nil
+}
+
+func effectNames() []string {
+	names := []string{"speed"}
+	for name := range effectPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func effectFilters(effects []Effect) (filters []string) {
+	for _, effect := range effects {
+		filters = append(filters, effect.filters...)
+	}
+	return
+}
+
+// effectTempo is how much faster than normal songs play with all of effects.
+func effectTempo(effects []Effect) float64 {
+	tempo := 1.0
+	for _, effect := range effects {
+		tempo *= effect.Tempo
+	}
+	return tempo
+}
+
+func describeEffects(effects []Effect) string {
+	names := make([]string, len(effects))
+	for i, effect := range effects {
+		names[i] = effect.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// atTempo is how far into a song playing for d at tempo gets.
+func atTempo(d time.Duration, tempo float64) time.Duration {
+	return time.Duration(float64(d) * tempo)
+}
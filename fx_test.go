@@ -0,0 +1,59 @@
+package musicbot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEffects(t *testing.T) {
+	tests := []struct {
+		args    []string
+		names   []string
+		filters []string
+		tempo   float64
+		ok      bool
+	}{
+		{[]string{"bassboost"}, []string{"bassboost"}, []string{"bass=g=10"}, 1, true},
+		{[]string{"Bassboost", "nightcore"}, []string{"bassboost", "nightcore"},
+			[]string{"bass=g=10", "aresample=48000", "asetrate=60000", "aresample=48000"}, 1.25, true},
+		{[]string{"speed"}, []string{"speed 1.25x"}, []string{"atempo=1.250"}, 1.25, true},
+		{[]string{"speed", "1.5x"}, []string{"speed 1.5x"}, []string{"atempo=1.500"}, 1.5, true},
+		{[]string{"speed", "0.5"}, []string{"speed 0.5x"}, []string{"atempo=0.500"}, 0.5, true},
+		{[]string{"speed", "2", "vaporwave"}, []string{"speed 2x", "vaporwave"},
+			[]string{"atempo=2.000", "aresample=48000", "asetrate=38400", "aresample=48000"}, 1.6, true},
+		// speed without a number is the default speed, followed by another effect
+		{[]string{"speed", "8d"}, []string{"speed 1.25x", "8d"}, []string{"atempo=1.250", "apulsator=hz=0.125"}, 1.25, true},
+		{[]string{"off"}, nil, nil, 1, true},
+		{[]string{"bassboost", "none"}, nil, nil, 1, true},
+		{[]string{"reverb"}, nil, nil, 0, false},
+		{[]string{"bassboost", "atempo=100"}, nil, nil, 0, false},
+		{[]string{"speed", "0.25"}, nil, nil, 0, false},
+		{[]string{"speed", "3x"}, nil, nil, 0, false},
+		{[]string{"speed", "-1"}, nil, nil, 0, false},
+		{[]string{"speed", "NaN"}, nil, nil, 0, false},
+		{[]string{"speed", "Inf"}, nil, nil, 0, false},
+	}
+	for _, test := range tests {
+		effects, err := parseEffects(test.args)
+		if (err == nil) != test.ok {
+			t.Errorf("parseEffects(%q) err = %v, want ok %v", test.args, err, test.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var names []string
+		for _, effect := range effects {
+			names = append(names, effect.Name)
+		}
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("parseEffects(%q) = %q, want %q", test.args, names, test.names)
+		}
+		if filters := effectFilters(effects); !reflect.DeepEqual(filters, test.filters) {
+			t.Errorf("parseEffects(%q) filters = %q, want %q", test.args, filters, test.filters)
+		}
+		if tempo := effectTempo(effects); tempo != test.tempo {
+			t.Errorf("parseEffects(%q) tempo = %v, want %v", test.args, tempo, test.tempo)
+		}
+	}
+}
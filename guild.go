@@ -1,6 +1,7 @@
 package musicbot
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -100,13 +101,41 @@ type GuildService struct {
 	store        GuildStorage
 	player       GuildPlayer
 	commands     []command
-	plugins      []plugins.Plugin
+	sources      []plugins.Source
+	audio        *plugins.AudioCache
+	// results of the search command waiting to be picked, by message id
+	searches map[string]pendingSearch
+	// lookups that have not finished, by message id
+	lookups map[string]pendingLookup
+	// finished lookups waiting to act in the guild service's goroutine
+	results chan func()
+	// ctx is done when the guild service stops
+	ctx  context.Context
+	stop context.CancelFunc
 }
 
-// GuildStorage persists and retrieves guild configuration.
+// GuildStorage persists and retrieves guild configuration and queued songs.
 type GuildStorage interface {
 	Get(guildID string) (GuildConfig, error)
 	Put(guildID string, info GuildConfig) error
+	// Queue is returned in the order it was saved.
+	Queue(guildID string) ([]QueuedSong, error)
+	// PutQueue replaces the saved queue of a guild.
+	PutQueue(guildID string, songs []QueuedSong) error
+	// History is returned most recent first.
+	History(guildID string, n int) ([]PlayedSong, error)
+	PutHistory(guildID string, song PlayedSong) error
+}
+
+// QueuedSong is enough information about a song in a guild's queue to resolve and queue it again.
+type QueuedSong struct {
+	Query     string    `json:"query"`
+	AuthorID  string    `json:"author"`
+	ChannelID string    `json:"channel"`
+	MessageID string    `json:"message"`
+	Queued    time.Time `json:"queued"`
+	// Attempts is how many times the song has failed to be queued again.
+	Attempts int `json:"attempts,omitempty"`
 }
 
 // GuildConfig controls some behavior of the GuildService.
@@ -122,6 +151,58 @@ type GuildConfig struct {
 	// Values less than -70.0 or greater than -5.0 have no effect.
 	// In particular, the default value of 0 has no effect and audio streams will be unchanged.
 	Loudness float64 `json:"loudness"`
+	// VoteSkip is the fraction of listeners in the music channel that must vote to skip a song.
+	// The person who queued the song and the owner of the guild can always skip immediately.
+	// Values less than or equal to 0 let anyone skip immediately.
+	VoteSkip float64 `json:"voteskip"`
+	// QueueLength limits how many songs can wait in the playlist.
+	// Values less than or equal to 0 use DefaultQueueLength.
+	QueueLength int `json:"queue"`
+	// MaxDuration limits how long a song can be, e.g. 15m.
+	// Values less than or equal to 0 have no limit.
+	MaxDuration time.Duration `json:"maxduration"`
+	// NoLivestreams rejects livestreams and radio stations.
+	NoLivestreams bool `json:"nolive"`
+	// UserQueueLength limits how many songs one person can have in the playlist.
+	// Values less than or equal to 0 have no limit.
+	UserQueueLength int `json:"userqueue"`
+	// SearchTimeout is how long the results of the search command can be picked from, e.g. 30s.
+	// Values less than or equal to 0 use DefaultSearchTimeout.
+	SearchTimeout time.Duration `json:"searchtimeout"`
+	// ResolveTimeout limits how long to look for something to play, e.g. 20s.
+	// Values less than or equal to 0 use DefaultResolveTimeout.
+	ResolveTimeout time.Duration `json:"resolvetimeout"`
+	// FairQueue takes turns between the people who queued songs, instead of playing songs in the order they were queued.
+	FairQueue bool `json:"fair"`
+	// Volume is how loud songs play, in percent of how they would play otherwise, up to MaxVolume.
+	// It applies after Loudness.
+	// Values less than 0 are silent, and the default value of 0 plays at DefaultVolume.
+	Volume int `json:"volume"`
+	// Crossfade is how long one song fades into the next, e.g. 3s.
+	// Values less than or equal to 0 do not crossfade.
+	Crossfade time.Duration `json:"crossfade"`
+	// Members with one of these roles can run commands that need a DJ.
+	DJRoles []string `json:"dj"`
+	// Permissions overrides the level of trust needed to run a command, by command name.
+	Permissions map[string]Permission `json:"permissions"`
+	// Sources are the sources of music to try, in order.
+	// If empty, the bot's sources are tried in the bot's order.
+	Sources []string `json:"sources"`
+	// DisabledSources are never tried.
+	DisabledSources []string `json:"disabled"`
+}
+
+// historyLength is the number of played songs remembered for each guild.
+const historyLength = 50
+
+// PlayedSong is a record of a song that has ended in a guild.
+type PlayedSong struct {
+	Title    string        `json:"title"`
+	Query    string        `json:"query"`
+	AuthorID string        `json:"author"`
+	Duration time.Duration `json:"duration"`
+	Elapsed  time.Duration `json:"elapsed"`
+	Played   time.Time     `json:"played"`
 }
 
 // NewGuild creates a new Guild with an underlying GuildService that is ready for GuildEvents.
@@ -131,7 +212,8 @@ func NewGuild(
 	store GuildStorage,
 	openPlayer func(idleChannelID string) GuildPlayer,
 	commands []command,
-	plugins []plugins.Plugin,
+	sources []plugins.Source,
+	audio *plugins.AudioCache,
 ) *Guild {
 	eventChan := make(chan GuildEvent)
 	listener := &Guild{
@@ -158,18 +240,35 @@ func NewGuild(
 		store:        store,
 		player:       openPlayer(info.MusicChannel),
 		commands:     commands,
-		plugins:      plugins,
+		sources:      sources,
+		audio:        audio,
+		searches:     make(map[string]pendingSearch),
+		lookups:      make(map[string]pendingLookup),
+		results:      make(chan func()),
 	}
+	gsvc.ctx, gsvc.stop = context.WithCancel(context.Background())
 
 	go func(events <-chan GuildEvent) {
-		for evt := range eventChan {
-			switch evt.Type {
-			case MessageEvent:
-				gsvc.HandleMessageEvent(evt)
-			case ReactEvent:
-				gsvc.HandleReactEvent(evt)
+		gsvc.restoreQueue()
+	loop:
+		for {
+			select {
+			case evt, ok := <-events:
+				if !ok {
+					break loop
+				}
+				switch evt.Type {
+				case MessageEvent:
+					gsvc.HandleMessageEvent(evt)
+				case ReactEvent:
+					gsvc.HandleReactEvent(evt)
+				}
+			case fn := <-gsvc.results:
+				fn()
 			}
 		}
+		// abandon lookups that have not finished
+		gsvc.stop()
 		gsvc.player.Close()
 		gsvc.store.Put(gsvc.guildID, gsvc.GuildConfig)
 		listener.wg.Done()
@@ -209,21 +308,139 @@ func (gsvc *GuildService) HandleMessageEvent(evt GuildEvent) {
 		return
 	}
 
-	fn, pluginOK := matchPlugin(gsvc.plugins, arg)
-	if !pluginOK {
+	if arg == "" {
 		return
 	}
-
 	log.Printf("evt %v -> plugin %v", evt, arg)
-	gsvc.runAndRespondToMessage(fn, evt, nil, requeue.ack)
+	gsvc.lookup(evt, requeue.ack, findPlugin(gsvc.plugins(), arg, gsvc.queueSpace()))
+}
+
+// queueSpace is how many more songs fit in the playlist.
+func (gsvc *GuildService) queueSpace() int {
+	length := gsvc.QueueLength
+	if length <= 0 {
+		length = DefaultQueueLength
+	}
+	if space := length - len(gsvc.player.Playlist()); space > 0 {
+		return space
+	}
+	return 0
+}
+
+// restoreQueue resolves and queues songs left over from the last time the guild service was open.
+func (gsvc *GuildService) restoreQueue() {
+	songs, err := gsvc.store.Queue(gsvc.guildID)
+	if err != nil {
+		log.Printf("failed to load saved songs %v", err)
+		return
+	}
+	if len(songs) == 0 {
+		return
+	}
+	// a song stays saved until it is put into the player again,
+	// so songs that fail to resolve are tried again the next time
+	gsvc.player.Restore(songs)
+
+	// one at a time, so that songs are queued in the same order as before
+	available, timeout := gsvc.plugins(), gsvc.resolveTimeout()
+	go func() {
+		for _, song := range songs {
+			song := song
+			evt := GuildEvent{
+				Type:      MessageEvent,
+				GuildID:   gsvc.guildID,
+				ChannelID: song.ChannelID,
+				MessageID: song.MessageID,
+				AuthorID:  song.AuthorID,
+				Body:      song.Query,
+			}
+			log.Printf("restore %v", evt)
+			ctx, cancel := context.WithTimeout(gsvc.ctx, timeout)
+			fn, err := findPlugin(available, song.Query, -1)(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("failed to restore song %v", err)
+				gsvc.later(func() { gsvc.player.RestoreFailed(song) })
+				continue
+			}
+			gsvc.later(func() {
+				if err := fn(gsvc, evt, nil); err != nil {
+					log.Printf("failed to restore song %v", err)
+					gsvc.player.RestoreFailed(song)
+				}
+			})
+		}
+	}()
 }
 
 func (gsvc *GuildService) isAllowed(cmd command, evt GuildEvent) bool {
 	channelOK := !cmd.restrictChannel || contains(gsvc.ListenChannels, evt.ChannelID)
-	authorOK := !cmd.ownerOnly || evt.AuthorID == gsvc.guildOwnerID
+	authorOK := gsvc.hasPermission(evt.AuthorID, gsvc.permission(cmd))
 	return channelOK && authorOK
 }
 
+// permission is the level of trust needed to run a command in this guild.
+func (gsvc *GuildService) permission(cmd command) Permission {
+	if p, ok := gsvc.Permissions[cmd.name]; ok {
+		return p
+	}
+	return cmd.permission
+}
+
+// enabledSources are the bot's sources that this guild uses, in the order the guild wants them tried.
+func (gsvc *GuildService) enabledSources() []plugins.Source {
+	order := gsvc.Sources
+	if len(order) == 0 {
+		order = make([]string, len(gsvc.sources))
+		for i, src := range gsvc.sources {
+			order[i] = src.Name
+		}
+	}
+	var enabled []plugins.Source
+	for _, name := range order {
+		if contains(gsvc.DisabledSources, name) {
+			continue
+		}
+		for _, src := range gsvc.sources {
+			if src.Name == name {
+				enabled = append(enabled, src)
+				break
+			}
+		}
+	}
+	return enabled
+}
+
+func (gsvc *GuildService) plugins() []plugins.Plugin {
+	return plugins.Plugins(gsvc.enabledSources())
+}
+
+func (gsvc *GuildService) hasPermission(userID string, p Permission) bool {
+	switch p {
+	case PermissionEveryone:
+		return true
+	case PermissionDJ:
+		if userID == gsvc.guildOwnerID || len(gsvc.DJRoles) == 0 {
+			return true
+		}
+		member, err := gsvc.discord.State.Member(gsvc.guildID, userID)
+		if err != nil {
+			member, err = gsvc.discord.GuildMember(gsvc.guildID, userID)
+			if err != nil {
+				return false
+			}
+		}
+		for _, role := range member.Roles {
+			if contains(gsvc.DJRoles, role) {
+				return true
+			}
+		}
+		return false
+	default:
+		return userID == gsvc.guildOwnerID
+	}
+}
+
 func (gsvc *GuildService) runAndRespondToMessage(fn serviceFunc, evt GuildEvent, args []string, ack string) {
 	err := fn(gsvc, evt, args)
 	// error response
@@ -238,21 +455,29 @@ func (gsvc *GuildService) runAndRespondToMessage(fn serviceFunc, evt GuildEvent,
 }
 
 // HandleReactEvent may invoke a command corresponding to the reacted emoji
-// if the reaction is to music player's status message or to a previously queued song.
+// if the reaction is to music player's status message or to a previously queued song,
+// or may queue a song if the reaction picks from the results of the search command.
 // musicbot puts its own reactions in these locations so users do not have to guess what emojis do what.
 func (gsvc *GuildService) HandleReactEvent(evt GuildEvent) {
+	if gsvc.cancelLookupByReaction(evt) || gsvc.pickSearchResult(evt) {
+		return
+	}
+
 	nowPlaying, ok := gsvc.player.NowPlaying()
 	if ok && evt.ChannelID == nowPlaying.StatusMessageChannelID && evt.MessageID == nowPlaying.StatusMessageID {
 		for _, cmd := range gsvc.commands {
 			if cmd.shortcut == evt.Body {
-				// no error response or success ack
-				_ = cmd.run(gsvc, evt, []string{})
+				if gsvc.isAllowed(cmd, evt) {
+					// no error response or success ack
+					_ = cmd.run(gsvc, evt, []string{})
+				}
 				return
 			}
 		}
 	}
 
-	if requeue.shortcut != evt.Body {
+	// the reacted message is replayed as its author, so check the person who reacted first
+	if requeue.shortcut != evt.Body || !gsvc.isAllowed(requeue, evt) {
 		return
 	}
 
@@ -278,6 +503,28 @@ func (gsvc *GuildService) HandleReactEvent(evt GuildEvent) {
 	}
 }
 
+// displayName is the nickname or username of a guild member, or the userID if the member is unknown.
+func (gsvc *GuildService) displayName(userID string) string {
+	member, err := gsvc.discord.State.Member(gsvc.guildID, userID)
+	if err != nil {
+		return userID
+	}
+	if member.Nick != "" {
+		return member.Nick
+	}
+	return member.User.Username
+}
+
+// listeners are the users other than musicbot in a voice channel
+func listeners(g *discordgo.Guild, voiceChannelID string, botID string) (userIDs []string) {
+	for _, vs := range g.VoiceStates {
+		if vs.ChannelID == voiceChannelID && vs.UserID != botID {
+			userIDs = append(userIDs, vs.UserID)
+		}
+	}
+	return
+}
+
 func detectMusicChannel(g *discordgo.Guild) string {
 	for _, ch := range g.Channels {
 		if ch.Type == discordgo.ChannelTypeGuildVoice && strings.HasPrefix(strings.ToLower(ch.Name), DefaultMusicChannelPrefix) {
@@ -1,29 +1,105 @@
 package musicbot
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"math/rand"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/jeffreymkabot/discordvoice"
 	"github.com/jeffreymkabot/discordvoice/discordvoice"
 	"github.com/jeffreymkabot/musicbot/plugins"
+	"github.com/pkg/errors"
 )
 
 // ErrInvalidMusicChannel is emitted when the music channel configured for a guild is not a discord voice channel.
 var ErrInvalidMusicChannel = errors.New("set a valid voice channel for music playback, then call reconnect")
 
+// ErrQueueFull is emitted when a song is put into a guild player whose queue is already full.
+var ErrQueueFull = errors.New("the playlist is full")
+
+// ErrInvalidQueueIndex is emitted when a queue operation refers to a position outside of the queue.
+var ErrInvalidQueueIndex = errors.New("no song at that position in the playlist")
+
+// DefaultQueueLength is the number of songs that can wait in a guild's playlist unless the guild configures otherwise.
+const DefaultQueueLength = 10
+
+// prefetchLead is how long before a song ends to open the next song.
+const prefetchLead = 5 * time.Second
+
+// DefaultVolume is the volume, in percent, that songs play at unless the guild configures otherwise.
+const DefaultVolume = 100
+
+// MaxVolume is the loudest a guild can make songs play, in percent.
+const MaxVolume = 200
+
+// maxRestoreAttempts is how many times a saved song can fail to be put again before it is no longer saved.
+const maxRestoreAttempts = 3
+
+// LoopMode controls what a guild player does with a song after it ends.
+type LoopMode int
+
+// LoopModes
+const (
+	LoopOff LoopMode = iota
+	// repeat the song that is playing until it is skipped
+	LoopTrack
+	// queue each song again after it ends
+	LoopQueue
+)
+
+func (mode LoopMode) String() string {
+	switch mode {
+	case LoopTrack:
+		return "track"
+	case LoopQueue:
+		return "queue"
+	default:
+		return "off"
+	}
+}
+
 // GuildPlayer streams audio to a voice channel in a guild.
+// Queue positions are zero-indexed, with 0 being the next song to play.
 type GuildPlayer interface {
-	Put(evt GuildEvent, voiceChannelID string, md plugins.Metadata, loudness float64) error
+	// Put queues a song to play in the guild's music channel.
+	// The guild's configuration at the time of the call decides where the song goes in the queue and how it plays.
+	Put(evt GuildEvent, cfg GuildConfig, query string, md plugins.Metadata) error
+	// PutFront is like Put, but the song will play next.
+	PutFront(evt GuildEvent, cfg GuildConfig, query string, md plugins.Metadata) error
 	Skip()
+	// VoteSkip counts a user's vote to skip the song that is playing
+	// and skips the song once the number of votes reaches required.
+	VoteSkip(userID string, required int) (votes int)
 	Pause()
 	Clear()
+	Remove(idx int) error
+	Move(from int, to int) error
+	Jump(idx int) error
+	// Seek plays the song that is playing again from a position.
+	Seek(to time.Duration) error
+	Shuffle()
+	Loop() LoopMode
+	SetLoop(mode LoopMode)
+	// Volume is in percent.
+	Volume() int
+	// SetVolume changes the volume of the song that is playing right away.
+	SetVolume(percent int)
+	Effects() []Effect
+	// SetEffects plays the song that is playing again with effects, from where it is, and later songs with effects too.
+	SetEffects(effects []Effect)
+	// Restore keeps songs saved from before the player opened saved until they are put again,
+	// or until putting them has failed too many times, see RestoreFailed.
+	Restore(songs []QueuedSong)
+	// RestoreFailed counts a failed attempt to put a song passed to Restore.
+	RestoreFailed(song QueuedSong)
 	Close() error
 	NowPlaying() (Play, bool)
 	Playlist() []string
@@ -32,13 +108,37 @@ type GuildPlayer interface {
 // Play holds data related to the playback of an audio stream in a guild.
 type Play struct {
 	plugins.Metadata
+	// Query is the input that was resolved to Metadata.
+	Query                  string
+	AuthorID               string
 	StatusMessageChannelID string
 	StatusMessageID        string
+	// Elapsed is the position in the song, counting any part that was seeked past.
+	Elapsed time.Duration
 }
 
+// song is waiting in or playing from a guild player's queue.
+type song struct {
+	evt            GuildEvent
+	voiceChannelID string
+	query          string
+	md             plugins.Metadata
+	loudness       float64
+	crossfade      time.Duration
+	// where the song starts playing, e.g. from a timestamped link or after a seek
+	offset time.Duration
+	queued time.Time
+	// opened ahead of time, see prefetch
+	prefetched *songStream
+}
+
+// guildPlayer keeps its own queue so that songs can be rearranged,
+// and hands songs to the underlying player one at a time.
 type guildPlayer struct {
 	guildID string
 	discord *discordgo.Session
+	store   GuildStorage
+	audio   *plugins.AudioCache
 	*player.Player
 	cmdShortcuts []string
 	mu           sync.Mutex
@@ -46,11 +146,44 @@ type guildPlayer struct {
 	// player state controlled by discordvoice#sender goroutine
 	// guild state controlled by musicbot#Guild goroutine
 	nowPlaying Play
+	// status message reused by each song that plays, until there is nothing left to play
+	statusChannelID string
+	statusMessageID string
+	// volume in percent, read by the stream that is playing
+	volume int32
+	// song that has been handed to the underlying player
+	current *song
+	effects []Effect
+	// position in the current song as of positionAt, which advances at tempo and does not advance while paused
+	position   time.Duration
+	positionAt time.Time
+	tempo      float64
+	paused     bool
+	queue      []*song
+	// saved songs that have not been put again since the player opened, see Restore
+	pending []QueuedSong
+	loop    LoopMode
+	// votes to skip the song that is playing
+	votes         map[string]struct{}
+	votesRequired int
+	// a song has been handed to the underlying player and has not ended
+	busy     bool
+	skipping bool
+	// the song that is playing is ending so that it can play again from a new offset
+	seeking bool
+	closed  bool
+
+	// saveMu orders writes of the queue to the store, which happen outside of mu
+	saveMu sync.Mutex
+	// the latest snapshot of the queue and the latest one written, see saveQueue
+	saves uint64
+	saved uint64
 }
 
 // NewGuildPlayer creates a GuildPlayer resource for a discord guild.
 // Existing open GuildPlayers for the same guild should be closed before making a new one to avoid interference.
-func NewGuildPlayer(guildID string, discord *discordgo.Session, idleChannelID string, cmdShortcuts []string) GuildPlayer {
+// Songs are kept on disk by audio, which can be nil.
+func NewGuildPlayer(guildID string, discord *discordgo.Session, store GuildStorage, idleChannelID string, cmdShortcuts []string, audio *plugins.AudioCache) GuildPlayer {
 	idle := func() {
 		if discordvoice.ValidVoiceChannel(discord, idleChannelID) {
 			discord.ChannelVoiceJoin(guildID, idleChannelID, false, true)
@@ -59,22 +192,170 @@ func NewGuildPlayer(guildID string, discord *discordgo.Session, idleChannelID st
 	return &guildPlayer{
 		guildID: guildID,
 		discord: discord,
+		store:   store,
+		audio:   audio,
 		Player: player.New(
 			discordvoice.New(discord, guildID, 150*time.Millisecond),
-			player.QueueLength(10),
+			player.QueueLength(1),
 			player.IdleFunc(idle, 1000),
 		),
 		cmdShortcuts: cmdShortcuts,
+		volume:       DefaultVolume,
 	}
 }
 
-func (gp *guildPlayer) Put(evt GuildEvent, voiceChannelID string, md plugins.Metadata, loudness float64) error {
-	if !discordvoice.ValidVoiceChannel(gp.discord, voiceChannelID) {
+func (gp *guildPlayer) Put(evt GuildEvent, cfg GuildConfig, query string, md plugins.Metadata) error {
+	return gp.put(evt, cfg, query, md, false)
+}
+
+func (gp *guildPlayer) PutFront(evt GuildEvent, cfg GuildConfig, query string, md plugins.Metadata) error {
+	return gp.put(evt, cfg, query, md, true)
+}
+
+func (gp *guildPlayer) put(evt GuildEvent, cfg GuildConfig, query string, md plugins.Metadata, front bool) error {
+	if !discordvoice.ValidVoiceChannel(gp.discord, cfg.MusicChannel) {
 		return ErrInvalidMusicChannel
 	}
 
+	if cfg.MaxDuration > 0 && md.Duration > cfg.MaxDuration {
+		return errors.Errorf("%v is longer than the limit of %v", prettyTime(md.Duration), prettyTime(cfg.MaxDuration))
+	}
+	if cfg.NoLivestreams && md.Live {
+		return errors.New("livestreams are not allowed in this guild")
+	}
+
+	queueLength := cfg.QueueLength
+	if queueLength <= 0 {
+		queueLength = DefaultQueueLength
+	}
+	// the guild's volume, not the song's
+	gp.SetVolume(configVolume(cfg.Volume))
+
+	gp.mu.Lock()
+	full := len(gp.queue) >= queueLength
+	queuedByAuthor := 0
+	for _, s := range gp.queue {
+		if s.evt.AuthorID == evt.AuthorID {
+			queuedByAuthor++
+		}
+	}
+	gp.mu.Unlock()
+	if full {
+		return ErrQueueFull
+	}
+	if cfg.UserQueueLength > 0 && queuedByAuthor >= cfg.UserQueueLength {
+		return errors.Errorf("you already have %d songs in the playlist", queuedByAuthor)
+	}
+
 	log.Printf("put %v", md.Title)
-	statusChannelID, statusMessageID := evt.ChannelID, ""
+	s := &song{
+		evt:            evt,
+		voiceChannelID: cfg.MusicChannel,
+		query:          query,
+		md:             md,
+		loudness:       cfg.Loudness,
+		crossfade:      cfg.Crossfade,
+		offset:         md.Start,
+		queued:         time.Now(),
+	}
+
+	gp.mu.Lock()
+	switch {
+	case front:
+		gp.queue = append([]*song{s}, gp.queue...)
+	case cfg.FairQueue:
+		idx := fairIndex(gp.queue, evt.AuthorID)
+		gp.queue = append(gp.queue[:idx], append([]*song{s}, gp.queue[idx:]...)...)
+	default:
+		gp.queue = append(gp.queue, s)
+	}
+	// a restored song is now saved as part of the queue
+	for idx, saved := range gp.pending {
+		if saved.Query == query && saved.MessageID == evt.MessageID {
+			gp.pending = append(gp.pending[:idx], gp.pending[idx+1:]...)
+			break
+		}
+	}
+	gp.trimPrefetchLocked()
+	gp.mu.Unlock()
+
+	gp.saveQueue()
+	gp.playNext()
+	return nil
+}
+
+// fairIndex is where a song from authorID goes in a queue that takes turns between the people who queued songs.
+// A person's nth song is in the nth round of turns, and a new song goes at the end of its round.
+func fairIndex(queue []*song, authorID string) int {
+	rounds := make(map[string]int)
+	for _, s := range queue {
+		rounds[s.evt.AuthorID]++
+	}
+	round := rounds[authorID]
+
+	seen := make(map[string]int)
+	for idx, s := range queue {
+		if seen[s.evt.AuthorID] > round {
+			return idx
+		}
+		seen[s.evt.AuthorID]++
+	}
+	return len(queue)
+}
+
+// playNext hands the song at the front of the queue to the underlying player, unless a song is already playing.
+func (gp *guildPlayer) playNext() {
+	for {
+		gp.mu.Lock()
+		if gp.busy {
+			gp.mu.Unlock()
+			return
+		}
+		if gp.closed || len(gp.queue) == 0 {
+			gp.mu.Unlock()
+			gp.clearStatus()
+			return
+		}
+		s := gp.queue[0]
+		gp.queue = gp.queue[1:]
+		gp.busy = true
+		gp.current = s
+		// until the song starts
+		gp.position, gp.positionAt, gp.tempo, gp.paused = s.offset, time.Now(), 1, true
+		gp.mu.Unlock()
+
+		err := gp.play(s)
+		if err == nil {
+			return
+		}
+		log.Printf("failed to play %v %v", s.md.Title, err)
+		gp.mu.Lock()
+		gp.busy = false
+		gp.current = nil
+		gp.mu.Unlock()
+		gp.saveQueue()
+	}
+}
+
+func (gp *guildPlayer) play(s *song) error {
+	md, evt := s.md, s.evt
+
+	gp.mu.Lock()
+	effects := gp.effects
+	stream := s.prefetched
+	s.prefetched = nil
+	gp.mu.Unlock()
+	// opened ahead of time for a different part of the song
+	if stream != nil && stream.offset != s.offset {
+		go stream.Close()
+		stream = nil
+	}
+	if stream != nil {
+		effects = stream.effects
+	}
+
+	statusChannelID, statusMessageID := gp.takeStatus(evt.ChannelID)
+	started := false
 	embed := &discordgo.MessageEmbed{
 		Color:  0xa680ee,
 		Footer: &discordgo.MessageEmbedFooter{},
@@ -87,13 +368,28 @@ func (gp *guildPlayer) Put(evt GuildEvent, voiceChannelID string, md plugins.Met
 		}
 		embed.Title = playPaused + md.Title
 		embed.Description = prettyTime(elapsed) + "/" + prettyTime(md.Duration)
+		if md.StreamTitle != nil {
+			if streamTitle := md.StreamTitle(); streamTitle != "" {
+				embed.Description = "🎵 " + streamTitle + "\n" + embed.Description
+			}
+		}
+		embed.Description += fmt.Sprintf("\n%s %d%%", louder.shortcut, gp.Volume())
+		if len(effects) > 0 {
+			embed.Description += "\n" + fx.ack + " " + describeEffects(effects)
+		}
+		if mode := gp.Loop(); mode != LoopOff {
+			embed.Description += "\n" + loop.shortcut + " " + mode.String()
+		}
+		if votes, required := gp.skipVotes(); votes > 0 {
+			embed.Description += fmt.Sprintf("\n%s %d/%d", skip.shortcut, votes, required)
+		}
 
 		embed.Fields = nil
 		if len(lst) > 0 {
 			embed.Fields = []*discordgo.MessageEmbedField{
 				&discordgo.MessageEmbedField{
 					Name:  "Playlist",
-					Value: strings.Join(lst, "\n"),
+					Value: strings.Join(numbered(lst), "\n"),
 				},
 			}
 		}
@@ -105,14 +401,7 @@ func (gp *guildPlayer) Put(evt GuildEvent, voiceChannelID string, md plugins.Met
 				return
 			}
 			statusMessageID = msg.ID
-
-			gp.mu.Lock()
-			gp.nowPlaying = Play{
-				Metadata:               md,
-				StatusMessageChannelID: msg.ChannelID,
-				StatusMessageID:        msg.ID,
-			}
-			gp.mu.Unlock()
+			gp.setStatus(statusChannelID, statusMessageID)
 
 			for _, emoji := range gp.cmdShortcuts {
 				if err := gp.discord.MessageReactionAdd(statusChannelID, statusMessageID, emoji); err != nil {
@@ -127,39 +416,597 @@ func (gp *guildPlayer) Put(evt GuildEvent, voiceChannelID string, md plugins.Met
 				}
 			}()
 		}
+
+		if !started {
+			started = true
+			gp.mu.Lock()
+			gp.nowPlaying = Play{
+				Metadata:               md,
+				Query:                  s.query,
+				AuthorID:               evt.AuthorID,
+				StatusMessageChannelID: statusChannelID,
+				StatusMessageID:        statusMessageID,
+			}
+			gp.mu.Unlock()
+		}
+	}
+
+	// the underlying player only knows about the part of the song after offset, played at tempo
+	offset := s.offset
+	tempo := effectTempo(effects)
+	// the part of the song that faded in while the song before it ended
+	var faded time.Duration
+	at := func(d time.Duration) time.Duration {
+		return offset + atTempo(faded+d, tempo)
+	}
+	open := func() (io.ReadCloser, error) {
+		if stream == nil {
+			var err error
+			stream, err = gp.openSong(s, offset, effects)
+			if err != nil {
+				return nil, err
+			}
+		}
+		faded = stream.position()
+		if md.Duration > 0 {
+			length := time.Duration(float64(md.Duration-offset) / tempo)
+			stream.onTransition(length, prefetchLead+s.crossfade, gp.prefetch, s.crossfade, gp.fadeInto)
+		}
+		return stream, nil
 	}
 
-	return gp.Enqueue(
-		voiceChannelID,
+	err := gp.Enqueue(
+		s.voiceChannelID,
 		md.Title,
-		md.OpenFunc,
-		player.Duration(md.Duration),
-		player.Loudness(loudness),
+		open,
+		player.Duration(time.Duration(float64(md.Duration-offset)/tempo)),
 		player.OnStart(func() {
-			refreshStatus(true, 0, gp.Playlist())
+			gp.setPosition(at(0), tempo, true)
+			refreshStatus(true, at(0), gp.Playlist())
+		}),
+		player.OnPause(func(d time.Duration) {
+			gp.setPosition(at(d), tempo, false)
+			refreshStatus(false, at(d), gp.Playlist())
+		}),
+		player.OnResume(func(d time.Duration) {
+			gp.setPosition(at(d), tempo, true)
+			refreshStatus(true, at(d), gp.Playlist())
 		}),
-		player.OnPause(func(d time.Duration) { refreshStatus(false, d, gp.Playlist()) }),
-		player.OnResume(func(d time.Duration) { refreshStatus(true, d, gp.Playlist()) }),
 		player.OnProgress(
 			func(d time.Duration, frameTimes []time.Duration) {
 				avg, dev, max, min := statistics(latenciesAsFloat(frameTimes))
 				embed.Footer.Text = fmt.Sprintf("avg %.3fms, dev %.3fms, max %.3fms, min %.3fms", avg, dev, max, min)
-				refreshStatus(true, d, gp.Playlist())
+				gp.setPosition(at(d), tempo, true)
+				refreshStatus(true, at(d), gp.Playlist())
 			},
 			5*time.Second,
 		),
 		player.OnEnd(func(d time.Duration, err error) {
-			log.Printf("read %v of %v, expected %v", d, md.Title, md.Duration)
+			log.Printf("read %v of %v from %v, expected %v", d, md.Title, offset, md.Duration)
 			log.Printf("reason: %v", err)
-			if statusMessageID != "" {
-				gp.discord.ChannelMessageDelete(statusChannelID, statusMessageID)
-				gp.mu.Lock()
-				gp.nowPlaying = Play{}
-				gp.mu.Unlock()
+			if stream != nil {
+				stream.cancel()
+			}
+			// the next song takes over the status message, see playNext
+			gp.mu.Lock()
+			gp.nowPlaying = Play{}
+			gp.mu.Unlock()
+			if !gp.finish(s, offset, atTempo(faded+d, tempo)) {
+				gp.discord.MessageReactionAdd(evt.ChannelID, evt.MessageID, requeue.shortcut)
+				gp.saveQueue()
 			}
-			gp.discord.MessageReactionAdd(evt.ChannelID, evt.MessageID, requeue.shortcut)
+			// do not block the underlying player while it finishes with this song
+			go gp.playNext()
 		}),
 	)
+	if err != nil && stream != nil {
+		go stream.Close()
+	}
+	return err
+}
+
+// songStream is the decoded audio of a song, from offset and with effects.
+type songStream struct {
+	*pcmReader
+	cancel  context.CancelFunc
+	offset  time.Duration
+	effects []Effect
+}
+
+func (ss *songStream) Close() error {
+	err := ss.pcmReader.Close()
+	ss.cancel()
+	return err
+}
+
+// openSong opens a song's audio, which stays open until it is closed.
+func (gp *guildPlayer) openSong(s *song, offset time.Duration, effects []Effect) (*songStream, error) {
+	var filters []string
+	// normalized here instead of by the underlying player, which would undo the volume
+	if loudnorm := loudnormFilter(s.loudness); loudnorm != "" {
+		filters = append(filters, loudnorm)
+	}
+	filters = append(filters, effectFilters(effects)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc, err := gp.audio.Open(ctx, s.md)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	decoded, err := decodeStream(ctx, rc, offset, filters)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &songStream{newPCMReader(decoded, &gp.volume), cancel, offset, effects}, nil
+}
+
+// prefetch opens the next song ahead of time, so that it can fade in and start without a gap.
+func (gp *guildPlayer) prefetch() {
+	gp.mu.Lock()
+	gp.trimPrefetchLocked()
+	next := gp.nextLocked()
+	if next == nil || next.prefetched != nil {
+		gp.mu.Unlock()
+		return
+	}
+	offset, effects := next.offset, gp.effects
+	gp.mu.Unlock()
+
+	stream, err := gp.openSong(next, offset, effects)
+	if err != nil {
+		log.Printf("failed to prefetch %v %v", next.md.Title, err)
+		return
+	}
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	if next.prefetched != nil || gp.nextLocked() != next {
+		go stream.Close()
+		return
+	}
+	next.prefetched = stream
+}
+
+// fadeInto is the stream of the next song, if it has been prefetched.
+func (gp *guildPlayer) fadeInto() *pcmReader {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	next := gp.nextLocked()
+	if next == nil || next.prefetched == nil {
+		return nil
+	}
+	return next.prefetched.pcmReader
+}
+
+// nextLocked is the song that plays when the song that is playing ends by itself, or nil if that is not known yet.
+func (gp *guildPlayer) nextLocked() *song {
+	if gp.closed || gp.seeking || gp.skipping || gp.loop == LoopTrack || len(gp.queue) == 0 {
+		return nil
+	}
+	return gp.queue[0]
+}
+
+// trimPrefetchLocked closes songs that were opened ahead of time but are no longer next.
+func (gp *guildPlayer) trimPrefetchLocked() {
+	for idx, s := range gp.queue {
+		if idx > 0 {
+			dropPrefetch(s)
+		}
+	}
+}
+
+func dropPrefetch(s *song) {
+	if s.prefetched != nil {
+		go s.prefetched.Close()
+		s.prefetched = nil
+	}
+}
+
+// takeStatus is the status message for a song that is about to play in channelID.
+// The message of the song before it is reused if it is in the same channel.
+func (gp *guildPlayer) takeStatus(channelID string) (string, string) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	if gp.statusMessageID != "" && gp.statusChannelID != channelID {
+		go gp.discord.ChannelMessageDelete(gp.statusChannelID, gp.statusMessageID)
+		gp.statusChannelID, gp.statusMessageID = "", ""
+	}
+	return channelID, gp.statusMessageID
+}
+
+func (gp *guildPlayer) setStatus(channelID string, messageID string) {
+	gp.mu.Lock()
+	gp.statusChannelID, gp.statusMessageID = channelID, messageID
+	gp.mu.Unlock()
+}
+
+// clearStatus deletes the status message once there is nothing left to play.
+func (gp *guildPlayer) clearStatus() {
+	gp.mu.Lock()
+	channelID, messageID := gp.statusChannelID, gp.statusMessageID
+	gp.statusChannelID, gp.statusMessageID = "", ""
+	gp.mu.Unlock()
+	if messageID != "" {
+		gp.discord.ChannelMessageDelete(channelID, messageID)
+	}
+}
+
+// finish puts a song that has ended back into the queue if the loop mode calls for it.
+// elapsed is how much played after offset.
+// Returns true if the song ended because of a seek, in which case it is already back at the front of the queue.
+func (gp *guildPlayer) finish(s *song, offset time.Duration, elapsed time.Duration) (seeked bool) {
+	gp.mu.Lock()
+	skipped, seeked := gp.skipping, gp.seeking
+	gp.busy, gp.skipping, gp.seeking = false, false, false
+	gp.current = nil
+	if seeked {
+		gp.mu.Unlock()
+		return
+	}
+	gp.votes, gp.votesRequired = nil, 0
+	// songs that play again start from the beginning
+	s.offset = 0
+
+	var played *PlayedSong
+	if !gp.closed && elapsed > 0 {
+		played = &PlayedSong{
+			Title:    s.md.Title,
+			Query:    s.query,
+			AuthorID: s.evt.AuthorID,
+			Duration: s.md.Duration,
+			Elapsed:  offset + elapsed,
+			Played:   time.Now(),
+		}
+	}
+
+	// a song that did not play at all would loop forever
+	switch {
+	case gp.closed || elapsed == 0:
+	case gp.loop == LoopTrack && !skipped:
+		gp.queue = append([]*song{s}, gp.queue...)
+	case gp.loop == LoopQueue:
+		gp.queue = append(gp.queue, s)
+	}
+	gp.mu.Unlock()
+
+	// outside of mu, like saveQueue, so that commands do not wait on the disk
+	if played != nil {
+		if err := gp.store.PutHistory(gp.guildID, *played); err != nil {
+			log.Printf("failed to save history %v", err)
+		}
+	}
+	return
+}
+
+// saveQueue saves the songs that have not finished, in the order they will play,
+// so that they can be queued again if the player closes before they finish.
+// The store is written outside of mu, and a save that is overtaken by a later one is skipped.
+// Songs that are still saved when the player closes are restored the next time the guild starts up.
+func (gp *guildPlayer) saveQueue() {
+	gp.mu.Lock()
+	if gp.closed {
+		gp.mu.Unlock()
+		return
+	}
+	gp.saves++
+	version := gp.saves
+	songs := gp.queuedSongsLocked()
+	gp.mu.Unlock()
+
+	gp.saveMu.Lock()
+	defer gp.saveMu.Unlock()
+	// the queue may have been cleared since the player closed, see Close
+	gp.mu.Lock()
+	closed := gp.closed
+	gp.mu.Unlock()
+	if closed || version <= gp.saved {
+		return
+	}
+	gp.saved = version
+	if err := gp.store.PutQueue(gp.guildID, songs); err != nil {
+		log.Printf("failed to save queue %v", err)
+	}
+}
+
+// queuedSongsLocked are the song that is playing, the queue, and the songs waiting to be restored.
+// Songs without a query cannot be resolved again and are not saved.
+func (gp *guildPlayer) queuedSongsLocked() []QueuedSong {
+	songs := gp.queue
+	// a song that is seeking is already back in the queue
+	if gp.current != nil && !gp.seeking {
+		songs = append([]*song{gp.current}, songs...)
+	}
+	var saved []QueuedSong
+	for _, s := range songs {
+		if s.query == "" {
+			continue
+		}
+		saved = append(saved, QueuedSong{
+			Query:     s.query,
+			AuthorID:  s.evt.AuthorID,
+			ChannelID: s.evt.ChannelID,
+			MessageID: s.evt.MessageID,
+			Queued:    s.queued,
+		})
+	}
+	return append(saved, gp.pending...)
+}
+
+func (gp *guildPlayer) Restore(songs []QueuedSong) {
+	gp.mu.Lock()
+	gp.pending = append(gp.pending, songs...)
+	gp.mu.Unlock()
+	gp.saveQueue()
+}
+
+func (gp *guildPlayer) RestoreFailed(song QueuedSong) {
+	gp.mu.Lock()
+	for idx, saved := range gp.pending {
+		if saved.Query != song.Query || saved.MessageID != song.MessageID {
+			continue
+		}
+		gp.pending[idx].Attempts++
+		if gp.pending[idx].Attempts >= maxRestoreAttempts {
+			log.Printf("gave up restoring %v", song.Query)
+			gp.pending = append(gp.pending[:idx], gp.pending[idx+1:]...)
+		}
+		break
+	}
+	gp.mu.Unlock()
+	gp.saveQueue()
+}
+
+// Clear removes any queued songs, but not the song that is playing.
+func (gp *guildPlayer) Clear() {
+	gp.mu.Lock()
+	for _, s := range gp.queue {
+		dropPrefetch(s)
+	}
+	gp.queue = nil
+	gp.mu.Unlock()
+	gp.saveQueue()
+}
+
+func (gp *guildPlayer) Remove(idx int) error {
+	gp.mu.Lock()
+	if idx < 0 || idx >= len(gp.queue) {
+		gp.mu.Unlock()
+		return ErrInvalidQueueIndex
+	}
+	dropPrefetch(gp.queue[idx])
+	gp.queue = append(gp.queue[:idx], gp.queue[idx+1:]...)
+	gp.trimPrefetchLocked()
+	gp.mu.Unlock()
+	gp.saveQueue()
+	return nil
+}
+
+func (gp *guildPlayer) Move(from int, to int) error {
+	gp.mu.Lock()
+	if from < 0 || from >= len(gp.queue) || to < 0 || to >= len(gp.queue) {
+		gp.mu.Unlock()
+		return ErrInvalidQueueIndex
+	}
+	s := gp.queue[from]
+	gp.queue = append(gp.queue[:from], gp.queue[from+1:]...)
+	gp.queue = append(gp.queue[:to], append([]*song{s}, gp.queue[to:]...)...)
+	gp.trimPrefetchLocked()
+	gp.mu.Unlock()
+	gp.saveQueue()
+	return nil
+}
+
+// Jump drops the songs queued before idx and skips the song that is playing.
+func (gp *guildPlayer) Jump(idx int) error {
+	gp.mu.Lock()
+	if idx < 0 || idx >= len(gp.queue) {
+		gp.mu.Unlock()
+		return ErrInvalidQueueIndex
+	}
+	for _, s := range gp.queue[:idx] {
+		dropPrefetch(s)
+	}
+	gp.queue = gp.queue[idx:]
+	gp.mu.Unlock()
+
+	gp.saveQueue()
+	gp.Skip()
+	return nil
+}
+
+func (gp *guildPlayer) Shuffle() {
+	gp.mu.Lock()
+	rand.Shuffle(len(gp.queue), func(i, j int) {
+		gp.queue[i], gp.queue[j] = gp.queue[j], gp.queue[i]
+	})
+	gp.trimPrefetchLocked()
+	gp.mu.Unlock()
+	gp.saveQueue()
+}
+
+// Seek ends the song that is playing and puts it back at the front of the queue to start from to.
+// Seeking again before the song ends only changes where it starts.
+func (gp *guildPlayer) Seek(to time.Duration) error {
+	gp.mu.Lock()
+	s := gp.current
+	if s == nil || gp.closed {
+		gp.mu.Unlock()
+		return errors.New("nothing playing")
+	}
+	if s.md.Live {
+		gp.mu.Unlock()
+		return errors.New("cannot seek in a livestream")
+	}
+	if to < 0 {
+		to = 0
+	}
+	if s.md.Duration > 0 && to >= s.md.Duration {
+		gp.mu.Unlock()
+		return errors.Errorf("%v is past the end of the song", prettyTime(to))
+	}
+	restart := gp.restartLocked(s, to)
+	gp.mu.Unlock()
+
+	if restart {
+		gp.Player.Skip()
+	}
+	return nil
+}
+
+// restartLocked puts the song that is playing back at the front of the queue to start from to.
+// Returns false if the song is already ending to be restarted.
+func (gp *guildPlayer) restartLocked(s *song, to time.Duration) bool {
+	s.offset = to
+	if gp.seeking {
+		return false
+	}
+	gp.seeking = true
+	gp.queue = append([]*song{s}, gp.queue...)
+	return true
+}
+
+func (gp *guildPlayer) Effects() []Effect {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	return gp.effects
+}
+
+func (gp *guildPlayer) SetEffects(effects []Effect) {
+	gp.mu.Lock()
+	gp.effects = effects
+	// opened with the effects before
+	for _, s := range gp.queue {
+		dropPrefetch(s)
+	}
+	s := gp.current
+	if s == nil || gp.closed {
+		gp.mu.Unlock()
+		return
+	}
+	// livestreams can only start from where they are now
+	to := time.Duration(0)
+	if !s.md.Live {
+		to = gp.elapsedLocked()
+	}
+	restart := gp.restartLocked(s, to)
+	gp.mu.Unlock()
+
+	if restart {
+		gp.Player.Skip()
+	}
+}
+
+func (gp *guildPlayer) setPosition(position time.Duration, tempo float64, playing bool) {
+	gp.mu.Lock()
+	gp.position, gp.positionAt, gp.tempo, gp.paused = position, time.Now(), tempo, !playing
+	gp.mu.Unlock()
+}
+
+func (gp *guildPlayer) elapsedLocked() time.Duration {
+	if gp.paused {
+		return gp.position
+	}
+	return gp.position + atTempo(time.Since(gp.positionAt), gp.tempo)
+}
+
+// Skip ends the song that is playing, even if the loop mode would repeat it.
+func (gp *guildPlayer) Skip() {
+	gp.mu.Lock()
+	gp.skipping = gp.busy
+	gp.mu.Unlock()
+	gp.Player.Skip()
+}
+
+func (gp *guildPlayer) VoteSkip(userID string, required int) (votes int) {
+	gp.mu.Lock()
+	if !gp.busy {
+		gp.mu.Unlock()
+		return 0
+	}
+	if gp.votes == nil {
+		gp.votes = make(map[string]struct{})
+	}
+	gp.votes[userID] = struct{}{}
+	gp.votesRequired = required
+	votes = len(gp.votes)
+	gp.mu.Unlock()
+
+	if votes >= required {
+		gp.Skip()
+	}
+	return
+}
+
+func (gp *guildPlayer) skipVotes() (votes int, required int) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	return len(gp.votes), gp.votesRequired
+}
+
+func (gp *guildPlayer) Loop() LoopMode {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	return gp.loop
+}
+
+func (gp *guildPlayer) SetLoop(mode LoopMode) {
+	gp.mu.Lock()
+	gp.loop = mode
+	gp.mu.Unlock()
+}
+
+func (gp *guildPlayer) Volume() int {
+	return int(atomic.LoadInt32(&gp.volume))
+}
+
+func (gp *guildPlayer) SetVolume(percent int) {
+	if percent < 0 {
+		percent = 0
+	} else if percent > MaxVolume {
+		percent = MaxVolume
+	}
+	atomic.StoreInt32(&gp.volume, int32(percent))
+}
+
+// configVolume is the volume, in percent, for GuildConfig.Volume.
+func configVolume(volume int) int {
+	switch {
+	case volume < 0:
+		return 0
+	case volume == 0:
+		return DefaultVolume
+	case volume > MaxVolume:
+		return MaxVolume
+	}
+	return volume
+}
+
+// Playlist lists the titles of queued songs in the order they will play.
+func (gp *guildPlayer) Playlist() []string {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	titles := make([]string, len(gp.queue))
+	for i, s := range gp.queue {
+		titles[i] = s.md.Title
+	}
+	return titles
+}
+
+// Close releases the voice resources of the player.
+// Songs that have not finished remain saved, and the saved queue is not written again once Close returns.
+func (gp *guildPlayer) Close() error {
+	gp.mu.Lock()
+	gp.closed = true
+	for _, s := range gp.queue {
+		dropPrefetch(s)
+	}
+	gp.queue = nil
+	gp.mu.Unlock()
+	// after this no save can overwrite what the caller does with the saved queue
+	gp.saveMu.Lock()
+	gp.saveMu.Unlock()
+	return gp.Player.Close()
 }
 
 func (gp *guildPlayer) NowPlaying() (play Play, ok bool) {
@@ -168,7 +1015,9 @@ func (gp *guildPlayer) NowPlaying() (play Play, ok bool) {
 	if gp.nowPlaying.StatusMessageID == "" {
 		return Play{}, false
 	}
-	return gp.nowPlaying, true
+	play = gp.nowPlaying
+	play.Elapsed = gp.elapsedLocked()
+	return play, true
 }
 
 func prettyTime(t time.Duration) string {
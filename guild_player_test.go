@@ -0,0 +1,94 @@
+package musicbot
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// putFair queues a song from each author in turn, the way guildPlayer.put does with FairQueue.
+func putFair(queue []*song, authors ...string) []*song {
+	for _, author := range authors {
+		s := &song{evt: GuildEvent{AuthorID: author}}
+		idx := fairIndex(queue, author)
+		queue = append(queue[:idx], append([]*song{s}, queue[idx:]...)...)
+	}
+	return queue
+}
+
+func queueAuthors(queue []*song) string {
+	authors := make([]string, len(queue))
+	for i, s := range queue {
+		authors[i] = s.evt.AuthorID
+	}
+	return strings.Join(authors, "")
+}
+
+func TestFairIndex(t *testing.T) {
+	tests := []struct {
+		puts string
+		want string
+	}{
+		{"", ""},
+		{"aaa", "aaa"},
+		{"aaab", "abaa"},
+		{"aaabbc", "abcaba"},
+		{"abab", "abab"},
+		{"aaaabbbbcc", "abcabcabab"},
+		// a newcomer goes at the end of the first round
+		{"ababc", "abcab"},
+		{"abcabcd", "abcdabc"},
+	}
+	for _, test := range tests {
+		authors := strings.Split(test.puts, "")
+		if test.puts == "" {
+			authors = nil
+		}
+		if got := queueAuthors(putFair(nil, authors...)); got != test.want {
+			t.Errorf("put %q = %q, want %q", test.puts, got, test.want)
+		}
+	}
+}
+
+// songs that play leave the front of the queue, and rounds are counted from what is left
+func TestFairIndexAfterPlaying(t *testing.T) {
+	queue := putFair(nil, "a", "a", "a", "b")
+	// a's first song plays, which leaves b and a in the first round
+	queue = queue[1:]
+	queue = putFair(queue, "c")
+	if got, want := queueAuthors(queue), "baca"; got != want {
+		t.Errorf("queue = %q, want %q", got, want)
+	}
+}
+
+// no author gets a second turn in a round before every author with songs left has had one
+func TestFairIndexRounds(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	authors := []string{"a", "b", "c", "d"}
+	for i := 0; i < 100; i++ {
+		var queue []*song
+		for n := r.Intn(20); n > 0; n-- {
+			queue = putFair(queue, authors[r.Intn(len(authors))])
+		}
+
+		remaining := make(map[string]int)
+		for _, s := range queue {
+			remaining[s.evt.AuthorID]++
+		}
+		round := make(map[string]bool)
+		for _, s := range queue {
+			author := s.evt.AuthorID
+			if round[author] {
+				// the round is over, so everyone with songs left must have had a turn
+				for other, left := range remaining {
+					if left > 0 && !round[other] {
+						t.Fatalf("%q took a second turn before %q in %q", author, other, queueAuthors(queue))
+					}
+				}
+				round = make(map[string]bool)
+			}
+			round[author] = true
+			remaining[author]--
+		}
+	}
+}
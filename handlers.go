@@ -31,8 +31,10 @@ func onGuildCreate(b *Bot) func(*discordgo.Session, *discordgo.GuildCreate) {
 			return NewGuildPlayer(
 				guildID,
 				b.discord,
+				b.db,
 				idleChannelID,
 				commandShortcuts(b.commands),
+				b.audio,
 			)
 		}
 
@@ -42,7 +44,8 @@ func onGuildCreate(b *Bot) func(*discordgo.Session, *discordgo.GuildCreate) {
 			b.db,
 			openPlayer,
 			b.commands,
-			b.plugins,
+			b.sources,
+			b.audio,
 		))
 	}
 }
@@ -116,25 +119,27 @@ func onDirectMessage(b *Bot, message *discordgo.Message, channel *discordgo.Chan
 
 func onMessageReactionAdd(b *Bot) func(*discordgo.Session, *discordgo.MessageReactionAdd) {
 	return func(session *discordgo.Session, react *discordgo.MessageReactionAdd) {
-		onReaction(b, session, react.MessageReaction)
+		onReaction(b, session, react.MessageReaction, false)
 	}
 }
 
 func onMessageReactionRemove(b *Bot) func(*discordgo.Session, *discordgo.MessageReactionRemove) {
 	return func(session *discordgo.Session, react *discordgo.MessageReactionRemove) {
-		onReaction(b, session, react.MessageReaction)
+		onReaction(b, session, react.MessageReaction, true)
 	}
 }
 
 // dispatch event to the corresponding guild service
-func onReaction(b *Bot, session *discordgo.Session, react *discordgo.MessageReaction) {
+func onReaction(b *Bot, session *discordgo.Session, react *discordgo.MessageReaction, removed bool) {
 	channel, err := session.State.Channel(react.ChannelID)
 	if err != nil {
 		return
 	}
 
+	// someone removing musicbot's own 🔎 cancels a lookup
+	ownLookup := removed && b.me != nil && react.UserID == b.me.ID && react.Emoji.Name == lookupReaction
 	member, err := session.State.Member(channel.GuildID, react.UserID)
-	if err != nil || member.User.Bot {
+	if err != nil || (member.User.Bot && !ownLookup) {
 		return
 	}
 
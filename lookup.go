@@ -0,0 +1,114 @@
+package musicbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultResolveTimeout is how long to look for something to play when a guild does not say.
+const DefaultResolveTimeout = 30 * time.Second
+
+const lookupReaction = "🔎"
+
+// pendingLookup is a search for something to play, happening outside the guild service's goroutine.
+type pendingLookup struct {
+	evt    GuildEvent
+	cancel context.CancelFunc
+}
+
+// finder looks for something to play.
+// The serviceFunc it returns acts on what was found, and runs in the guild service's goroutine.
+type finder func(ctx context.Context) (serviceFunc, error)
+
+// lookup runs find in its own goroutine so that slow plugins do not hold up the guild service,
+// then runs what find returns in the guild service's goroutine, responding to evt's message with the result.
+// The message wears a 🔎 until the lookup is done.
+// The lookup is abandoned after the guild's ResolveTimeout, by the cancel command, or by removing the 🔎.
+func (gsvc *GuildService) lookup(evt GuildEvent, ack string, find finder) {
+	// a message replayed while its lookup is still going
+	if _, ok := gsvc.lookups[evt.MessageID]; ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(gsvc.ctx, gsvc.resolveTimeout())
+	gsvc.lookups[evt.MessageID] = pendingLookup{evt: evt, cancel: cancel}
+	gsvc.discord.MessageReactionAdd(evt.ChannelID, evt.MessageID, lookupReaction)
+
+	go func() {
+		fn, err := find(ctx)
+		gsvc.later(func() {
+			reason := ctx.Err()
+			cancel()
+			delete(gsvc.lookups, evt.MessageID)
+			gsvc.discord.MessageReactionRemove(evt.ChannelID, evt.MessageID, lookupReaction, "@me")
+			switch {
+			case reason == context.Canceled:
+				log.Printf("evt %v -> cancelled", evt)
+			case err != nil && reason == context.DeadlineExceeded:
+				gsvc.discord.ChannelMessageSend(evt.ChannelID, fmt.Sprintf("🤔...\ngave up looking after %v", gsvc.resolveTimeout()))
+			case err != nil:
+				gsvc.discord.ChannelMessageSend(evt.ChannelID, fmt.Sprintf("🤔...\n%v", err))
+			default:
+				gsvc.runAndRespondToMessage(fn, evt, nil, ack)
+			}
+		})
+	}()
+}
+
+// later runs fn in the guild service's goroutine, unless the guild service has stopped.
+func (gsvc *GuildService) later(fn func()) {
+	select {
+	case gsvc.results <- fn:
+	case <-gsvc.ctx.Done():
+	}
+}
+
+func (gsvc *GuildService) resolveTimeout() time.Duration {
+	if gsvc.ResolveTimeout <= 0 {
+		return DefaultResolveTimeout
+	}
+	return gsvc.ResolveTimeout
+}
+
+// cancelLookupByReaction abandons a lookup when the person who asked for it toggles its 🔎,
+// or when someone allowed to remove musicbot's reactions removes the 🔎.
+// Returns false if the event is not about a lookup.
+func (gsvc *GuildService) cancelLookupByReaction(evt GuildEvent) bool {
+	if evt.Body != lookupReaction {
+		return false
+	}
+	pending, ok := gsvc.lookups[evt.MessageID]
+	if !ok {
+		return false
+	}
+	me := gsvc.discord.State.User
+	if evt.AuthorID == pending.evt.AuthorID || (me != nil && evt.AuthorID == me.ID) {
+		pending.cancel()
+	}
+	return true
+}
+
+var cancelLookups = command{
+	name:  "cancel",
+	alias: []string{"nvm"},
+	usage: "cancel",
+	long:  "Stop looking for the songs you asked for.  DJs stop looking for everyone's songs.\nRemoving the " + lookupReaction + " from your message does the same for one song.",
+	ack:   "🆗",
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		isDJ := gsvc.hasPermission(evt.AuthorID, PermissionDJ)
+		n := 0
+		for _, pending := range gsvc.lookups {
+			if isDJ || pending.evt.AuthorID == evt.AuthorID {
+				pending.cancel()
+				n++
+			}
+		}
+		if n == 0 {
+			return errors.New("not looking for anything")
+		}
+		return nil
+	},
+}
@@ -0,0 +1,307 @@
+package plugins
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAudioCacheSize is how many bytes of audio an AudioCache keeps unless it is told otherwise.
+const DefaultAudioCacheSize = 1 << 30
+
+// partial files are still being written, or were left behind by a play that did not finish
+const partialPrefix = "partial-"
+
+// AudioCache keeps the audio of tracks on disk, so that tracks played again do not have to be downloaded again.
+// The least recently played tracks are deleted to keep the cache under its size.
+// AudioCache is safe to use in multiple goroutines.
+type AudioCache struct {
+	dir     string
+	maxSize int64
+
+	hits   uint64
+	misses uint64
+
+	mu      sync.Mutex
+	entries map[string]*audioEntry
+	size    int64
+	// tracks being saved by a play that has not finished
+	writing map[string]bool
+}
+
+type audioEntry struct {
+	size int64
+	used time.Time
+}
+
+// NewAudioCache keeps audio in dir, which is made if it does not exist.
+// Audio that is already in dir is kept, and what was played least recently is deleted if dir holds more than maxSize bytes.
+// Values of maxSize less than or equal to 0 use DefaultAudioCacheSize.
+func NewAudioCache(dir string, maxSize int64) (*AudioCache, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultAudioCacheSize
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	ac := &AudioCache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*audioEntry),
+		writing: make(map[string]bool),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(fi.Name(), partialPrefix) {
+			os.Remove(filepath.Join(dir, fi.Name()))
+			continue
+		}
+		// the modification time is when the file was last played, see Open
+		ac.entries[fi.Name()] = &audioEntry{size: fi.Size(), used: fi.ModTime()}
+		ac.size += fi.Size()
+	}
+	ac.mu.Lock()
+	ac.evictLocked()
+	ac.mu.Unlock()
+	return ac, nil
+}
+
+// Open opens the audio of md from the cache.
+// Audio that is not in the cache is opened by md.OpenFunc and saved to the cache as it is read,
+// unless it is closed before it has all been read.
+// Livestreams and files on this computer are never saved.
+// Open is md.OpenFunc if ac is nil.
+func (ac *AudioCache) Open(ctx context.Context, md Metadata) (io.ReadCloser, error) {
+	if ac == nil || md.Live || md.URL == "" || md.Source == sourceLibrary {
+		return md.OpenFunc(ctx)
+	}
+	key := audioKey(md)
+	path := filepath.Join(ac.dir, key)
+
+	ac.mu.Lock()
+	entry, ok := ac.entries[key]
+	if ok {
+		now := time.Now()
+		entry.used = now
+		// remember when it was played across restarts
+		os.Chtimes(path, now, now)
+	}
+	ac.mu.Unlock()
+	if ok {
+		f, err := os.Open(path)
+		if err == nil {
+			atomic.AddUint64(&ac.hits, 1)
+			return f, nil
+		}
+		log.Printf("failed to open cached audio %v %v", md.Title, err)
+		ac.remove(key)
+	}
+	atomic.AddUint64(&ac.misses, 1)
+
+	rc, err := md.OpenFunc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.writing[key] {
+		return rc, nil
+	}
+	f, err := ioutil.TempFile(ac.dir, partialPrefix+key)
+	if err != nil {
+		log.Printf("failed to cache audio %v %v", md.Title, err)
+		return rc, nil
+	}
+	ac.writing[key] = true
+	return &teeReadCloser{ReadCloser: rc, f: f, cache: ac, key: key}, nil
+}
+
+// Stats are how many plays were read from the cache, how many had to be downloaded,
+// and how many tracks and bytes are in the cache.
+func (ac *AudioCache) Stats() (hits uint64, misses uint64, tracks int, size int64) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return atomic.LoadUint64(&ac.hits), atomic.LoadUint64(&ac.misses), len(ac.entries), ac.size
+}
+
+// MaxSize is how many bytes the cache can hold.
+func (ac *AudioCache) MaxSize() int64 {
+	return ac.maxSize
+}
+
+// Purge deletes everything in the cache, and returns how many tracks it deleted.
+// Tracks that are being saved are still saved when they finish.
+func (ac *AudioCache) Purge() (int, error) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	n := 0
+	var err error
+	for key := range ac.entries {
+		if e := ac.removeLocked(key); e != nil {
+			err = e
+			continue
+		}
+		n++
+	}
+	return n, err
+}
+
+func (ac *AudioCache) remove(key string) {
+	ac.mu.Lock()
+	ac.removeLocked(key)
+	ac.mu.Unlock()
+}
+
+func (ac *AudioCache) removeLocked(key string) error {
+	entry, ok := ac.entries[key]
+	if !ok {
+		return nil
+	}
+	err := os.Remove(filepath.Join(ac.dir, key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(ac.entries, key)
+	ac.size -= entry.size
+	return nil
+}
+
+// add keeps a file that has all of a track's audio.
+func (ac *AudioCache) add(key string, partial string, size int64) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	delete(ac.writing, key)
+	// it would evict everything else and then itself
+	if size > ac.maxSize {
+		os.Remove(partial)
+		return
+	}
+	// a purge could have happened while the track played, which is fine,
+	// but the same track could also have been saved again
+	ac.removeLocked(key)
+	if err := os.Rename(partial, filepath.Join(ac.dir, key)); err != nil {
+		log.Printf("failed to cache audio %v", err)
+		os.Remove(partial)
+		return
+	}
+	ac.entries[key] = &audioEntry{size: size, used: time.Now()}
+	ac.size += size
+	ac.evictLocked()
+}
+
+// abandon throws away a file that does not have all of a track's audio.
+func (ac *AudioCache) abandon(key string, partial string) {
+	ac.mu.Lock()
+	delete(ac.writing, key)
+	ac.mu.Unlock()
+	os.Remove(partial)
+}
+
+// evictLocked deletes the tracks played least recently until the cache is under its size.
+func (ac *AudioCache) evictLocked() {
+	if ac.size <= ac.maxSize {
+		return
+	}
+	keys := make([]string, 0, len(ac.entries))
+	for key := range ac.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return ac.entries[keys[i]].used.Before(ac.entries[keys[j]].used)
+	})
+	for _, key := range keys {
+		if ac.size <= ac.maxSize {
+			return
+		}
+		if err := ac.removeLocked(key); err != nil {
+			log.Printf("failed to evict cached audio %v", err)
+		}
+	}
+}
+
+// audioKey identifies a track by where it comes from, however it was asked for.
+func audioKey(md Metadata) string {
+	sum := sha1.Sum([]byte(md.Source + "\x00" + normalize(md.URL)))
+	return hex.EncodeToString(sum[:])
+}
+
+// teeReadCloser saves what is read to a file,
+// which is added to the cache if everything is read before it is closed.
+type teeReadCloser struct {
+	io.ReadCloser
+	cache *AudioCache
+	key   string
+
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+	complete bool
+}
+
+func (trc *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := trc.ReadCloser.Read(p)
+	trc.mu.Lock()
+	defer trc.mu.Unlock()
+	if trc.f == nil {
+		return n, err
+	}
+	if n > 0 {
+		// stop saving tracks that will not fit in the cache as soon as they do not
+		if trc.size+int64(n) > trc.cache.maxSize {
+			trc.abandonLocked()
+			return n, err
+		}
+		if _, writeErr := trc.f.Write(p[:n]); writeErr != nil {
+			log.Printf("failed to cache audio %v", writeErr)
+			trc.abandonLocked()
+			return n, err
+		}
+		trc.size += int64(n)
+	}
+	if err == io.EOF {
+		trc.complete = true
+	}
+	return n, err
+}
+
+// abandonLocked stops saving what is read.
+func (trc *teeReadCloser) abandonLocked() {
+	trc.f.Close()
+	trc.cache.abandon(trc.key, trc.f.Name())
+	trc.f = nil
+}
+
+func (trc *teeReadCloser) Close() error {
+	err := trc.ReadCloser.Close()
+	trc.mu.Lock()
+	defer trc.mu.Unlock()
+	if trc.f == nil {
+		return err
+	}
+	f := trc.f
+	trc.f = nil
+	if closeErr := f.Close(); closeErr != nil || !trc.complete || trc.size == 0 {
+		trc.cache.abandon(trc.key, f.Name())
+		return err
+	}
+	trc.cache.add(trc.key, f.Name(), trc.size)
+	return err
+}
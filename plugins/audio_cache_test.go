@@ -0,0 +1,213 @@
+package plugins
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countingTrack is a track whose audio is content, and counts how many times it is opened.
+func countingTrack(url string, content string, opens *int) Metadata {
+	return Metadata{
+		Title:  url,
+		URL:    url,
+		Source: "test",
+		OpenFunc: func(context.Context) (io.ReadCloser, error) {
+			*opens++
+			return ioutil.NopCloser(strings.NewReader(content)), nil
+		},
+	}
+}
+
+// play opens md from the cache and reads n bytes of it, or all of it if n is negative.
+func play(t *testing.T, ac *AudioCache, md Metadata, n int64) string {
+	rc, err := ac.Open(context.Background(), md)
+	if err != nil {
+		t.Fatalf("Open failed %v", err)
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if n >= 0 {
+		r = io.LimitReader(rc, n)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read failed %v", err)
+	}
+	return string(b)
+}
+
+func newTestAudioCache(t *testing.T, maxSize int64) (*AudioCache, func()) {
+	dir, err := ioutil.TempDir("", "audiocache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac, err := NewAudioCache(dir, maxSize)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewAudioCache failed %v", err)
+	}
+	return ac, func() { os.RemoveAll(dir) }
+}
+
+// partialFiles are the files in the cache's directory that were never finished.
+func partialFiles(t *testing.T, ac *AudioCache) []string {
+	matches, err := filepath.Glob(filepath.Join(ac.dir, partialPrefix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestAudioCacheHit(t *testing.T) {
+	ac, cleanup := newTestAudioCache(t, 100)
+	defer cleanup()
+
+	opens := 0
+	md := countingTrack("https://example.com/a", "audio", &opens)
+	for i := 0; i < 2; i++ {
+		if got := play(t, ac, md, -1); got != "audio" {
+			t.Errorf("play %d read %q, want %q", i, got, "audio")
+		}
+	}
+	if opens != 1 {
+		t.Errorf("opened the track %d times, want 1", opens)
+	}
+	if hits, misses, tracks, size := ac.Stats(); hits != 1 || misses != 1 || tracks != 1 || size != 5 {
+		t.Errorf("Stats = %d %d %d %d, want 1 1 1 5", hits, misses, tracks, size)
+	}
+
+	// however it was asked for
+	md.URL = "https://www.example.com/a?utm_source=x"
+	play(t, ac, md, -1)
+	if opens != 1 {
+		t.Errorf("opened the track %d times, want 1", opens)
+	}
+
+	// and after a restart
+	restarted, err := NewAudioCache(ac.dir, 100)
+	if err != nil {
+		t.Fatalf("NewAudioCache failed %v", err)
+	}
+	play(t, restarted, md, -1)
+	if opens != 1 {
+		t.Errorf("opened the track %d times after a restart, want 1", opens)
+	}
+}
+
+func TestAudioCacheNotSaved(t *testing.T) {
+	tests := []struct {
+		name string
+		md   func(*int) Metadata
+		read int64
+	}{
+		{"partly played", func(opens *int) Metadata {
+			return countingTrack("https://example.com/a", "audio", opens)
+		}, 2},
+		{"live", func(opens *int) Metadata {
+			md := countingTrack("https://example.com/live", "audio", opens)
+			md.Live = true
+			return md
+		}, -1},
+		{"library", func(opens *int) Metadata {
+			md := countingTrack("/music/a.mp3", "audio", opens)
+			md.Source = sourceLibrary
+			return md
+		}, -1},
+		{"bigger than the cache", func(opens *int) Metadata {
+			return countingTrack("https://example.com/long", strings.Repeat("audio", 100), opens)
+		}, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ac, cleanup := newTestAudioCache(t, 100)
+			defer cleanup()
+
+			opens := 0
+			md := test.md(&opens)
+			play(t, ac, md, test.read)
+			play(t, ac, md, -1)
+			if opens != 2 {
+				t.Errorf("opened the track %d times, want 2", opens)
+			}
+			if partial := partialFiles(t, ac); len(partial) > 0 {
+				t.Errorf("left %v behind", partial)
+			}
+		})
+	}
+}
+
+func TestAudioCacheBiggerThanCacheKeepsOthers(t *testing.T) {
+	ac, cleanup := newTestAudioCache(t, 10)
+	defer cleanup()
+
+	opens := 0
+	small := countingTrack("https://example.com/small", "audio", &opens)
+	play(t, ac, small, -1)
+	// stops being saved as soon as it is too big, without evicting anything
+	play(t, ac, countingTrack("https://example.com/big", strings.Repeat("audio", 3), &opens), -1)
+	play(t, ac, small, -1)
+	if opens != 2 {
+		t.Errorf("opened tracks %d times, want 2", opens)
+	}
+	if _, _, tracks, size := ac.Stats(); tracks != 1 || size != 5 {
+		t.Errorf("Stats = %d tracks %d bytes, want 1 5", tracks, size)
+	}
+}
+
+func TestAudioCacheEviction(t *testing.T) {
+	ac, cleanup := newTestAudioCache(t, 10)
+	defer cleanup()
+
+	opens := make(map[string]*int)
+	track := func(name string) Metadata {
+		if opens[name] == nil {
+			opens[name] = new(int)
+		}
+		return countingTrack("https://example.com/"+name, "four", opens[name])
+	}
+	play(t, ac, track("a"), -1)
+	play(t, ac, track("b"), -1)
+	// a was played more recently than b
+	play(t, ac, track("a"), -1)
+	play(t, ac, track("c"), -1)
+
+	if _, _, tracks, size := ac.Stats(); tracks != 2 || size != 8 {
+		t.Errorf("Stats = %d tracks %d bytes, want 2 8", tracks, size)
+	}
+	for name, evicted := range map[string]bool{"a": false, "b": true, "c": false} {
+		_, err := os.Stat(filepath.Join(ac.dir, audioKey(track(name))))
+		if evicted != os.IsNotExist(err) {
+			t.Errorf("%v evicted %v, want %v", name, os.IsNotExist(err), evicted)
+		}
+	}
+}
+
+func TestAudioCachePurge(t *testing.T) {
+	ac, cleanup := newTestAudioCache(t, 100)
+	defer cleanup()
+
+	opens := 0
+	a := countingTrack("https://example.com/a", "audio", &opens)
+	play(t, ac, a, -1)
+	play(t, ac, countingTrack("https://example.com/b", "audio", &opens), -1)
+
+	n, err := ac.Purge()
+	if err != nil || n != 2 {
+		t.Errorf("Purge = %d %v, want 2", n, err)
+	}
+	if _, _, tracks, size := ac.Stats(); tracks != 0 || size != 0 {
+		t.Errorf("Stats = %d tracks %d bytes, want 0 0", tracks, size)
+	}
+	if files, _ := ioutil.ReadDir(ac.dir); len(files) > 0 {
+		t.Errorf("Purge left %d files", len(files))
+	}
+	play(t, ac, a, -1)
+	if opens != 3 {
+		t.Errorf("opened tracks %d times, want 3", opens)
+	}
+}
@@ -0,0 +1,243 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+type audioTags struct {
+	title    string
+	artist   string
+	album    string
+	duration time.Duration
+}
+
+// readTags looks for tags in the beginning of an audio file.
+// size is the length of the whole file, used to estimate the duration of mp3s without a length tag.
+func readTags(head []byte, size int64) audioTags {
+	switch sniffAudio(head) {
+	case "flac":
+		return readFlacTags(head)
+	case "mp3":
+		tags, tagLength := readID3(head)
+		if tags.duration == 0 && size > 0 && tagLength < len(head) {
+			if bitrate := mpegBitrate(head[tagLength:]); bitrate > 0 {
+				// bits divided by kilobits per second is milliseconds
+				tags.duration = time.Duration((size-int64(tagLength))*8/int64(bitrate)) * time.Millisecond
+			}
+		}
+		return tags
+	}
+	return audioTags{}
+}
+
+// readID3 reads the title, artist, album, and length frames of an ID3v2.3 or ID3v2.4 tag.
+// Also returns the length of the whole tag, which is where the audio begins.
+func readID3(head []byte) (tags audioTags, tagLength int) {
+	if len(head) < 10 || !bytes.HasPrefix(head, []byte("ID3")) {
+		return
+	}
+	version := head[3]
+	tagLength = 10 + synchsafe(head[6:10])
+	// skip the extended header
+	pos := 10
+	if head[5]&0x40 != 0 && len(head) >= 14 {
+		if version == 4 {
+			pos += synchsafe(head[10:14])
+		} else {
+			pos += 4 + int(binary.BigEndian.Uint32(head[10:14]))
+		}
+	}
+
+	end := tagLength
+	if end > len(head) {
+		end = len(head)
+	}
+	for pos+10 <= end {
+		id := string(head[pos : pos+4])
+		if id[0] == 0 {
+			// padding
+			break
+		}
+		var frameLength int
+		if version == 4 {
+			frameLength = synchsafe(head[pos+4 : pos+8])
+		} else {
+			frameLength = int(binary.BigEndian.Uint32(head[pos+4 : pos+8]))
+		}
+		pos += 10
+		if frameLength <= 0 || pos+frameLength > end {
+			break
+		}
+		frame := head[pos : pos+frameLength]
+		pos += frameLength
+
+		switch id {
+		case "TIT2":
+			tags.title = id3Text(frame)
+		case "TPE1":
+			tags.artist = id3Text(frame)
+		case "TALB":
+			tags.album = id3Text(frame)
+		case "TLEN":
+			if ms, err := strconv.Atoi(id3Text(frame)); err == nil {
+				tags.duration = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return
+}
+
+// synchsafe integers use 7 bits of each byte
+func synchsafe(b []byte) int {
+	n := 0
+	for _, v := range b {
+		n = n<<7 | int(v&0x7F)
+	}
+	return n
+}
+
+// id3Text decodes a text frame, whose first byte is its encoding
+func id3Text(frame []byte) string {
+	if len(frame) == 0 {
+		return ""
+	}
+	text := frame[1:]
+	switch frame[0] {
+	case 1, 2:
+		return strings.TrimRight(decodeUTF16(text, frame[0] == 2), "\x00")
+	default:
+		// ISO-8859-1 and UTF-8, close enough for titles
+		return strings.TrimRight(string(text), "\x00")
+	}
+}
+
+// decodeUTF16 uses the byte order mark if there is one
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xFF && b[1] == 0xFE:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xFE && b[1] == 0xFF:
+			bigEndian, b = true, b[2:]
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// kbps of MPEG-1 layer III, indexed by the bitrate bits of a frame header
+var mpeg1Layer3Bitrates = []int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+
+// kbps of MPEG-2 and MPEG-2.5 layer III
+var mpeg2Layer3Bitrates = []int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+
+// mpegBitrate finds the first layer III frame header and returns its bitrate in kbps, or 0 if there is none.
+// Variable bitrate files will get a rough estimate.
+func mpegBitrate(audio []byte) int {
+	for i := 0; i+4 <= len(audio); i++ {
+		if audio[i] != 0xFF || audio[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version := (audio[i+1] >> 3) & 0x03
+		layer := (audio[i+1] >> 1) & 0x03
+		index := audio[i+2] >> 4
+		// layer bits 01 are layer III, version bits 01 are reserved
+		if layer != 1 || version == 1 {
+			continue
+		}
+		if version == 3 {
+			return mpeg1Layer3Bitrates[index]
+		}
+		return mpeg2Layer3Bitrates[index]
+	}
+	return 0
+}
+
+// readFlacTags reads the duration from the STREAMINFO block and the title, artist, and album from the VORBIS_COMMENT block.
+func readFlacTags(head []byte) (tags audioTags) {
+	pos := 4
+	for pos+4 <= len(head) {
+		last := head[pos]&0x80 != 0
+		blockType := head[pos] & 0x7F
+		length := int(head[pos+1])<<16 | int(head[pos+2])<<8 | int(head[pos+3])
+		pos += 4
+		if pos+length > len(head) {
+			break
+		}
+		block := head[pos : pos+length]
+		pos += length
+
+		switch blockType {
+		case 0:
+			if len(block) >= 18 {
+				sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+				samples := int64(block[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+				if sampleRate > 0 {
+					tags.duration = time.Duration(samples * int64(time.Second) / sampleRate)
+				}
+			}
+		case 4:
+			for key, val := range vorbisComments(block) {
+				switch key {
+				case "TITLE":
+					tags.title = val
+				case "ARTIST":
+					tags.artist = val
+				case "ALBUM":
+					tags.album = val
+				}
+			}
+		}
+		if last {
+			break
+		}
+	}
+	return
+}
+
+// vorbisComments are little endian length-prefixed KEY=value strings after a vendor string
+func vorbisComments(block []byte) map[string]string {
+	comments := make(map[string]string)
+	next := func() (string, bool) {
+		if len(block) < 4 {
+			return "", false
+		}
+		length := int(binary.LittleEndian.Uint32(block))
+		if length > len(block)-4 {
+			return "", false
+		}
+		s := string(block[4 : 4+length])
+		block = block[4+length:]
+		return s, true
+	}
+	if _, ok := next(); !ok {
+		return comments
+	}
+	if len(block) < 4 {
+		return comments
+	}
+	count := int(binary.LittleEndian.Uint32(block))
+	block = block[4:]
+	for i := 0; i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			break
+		}
+		if idx := strings.Index(comment, "="); idx > 0 {
+			comments[strings.ToUpper(comment[:idx])] = comment[idx+1:]
+		}
+	}
+	return comments
+}
@@ -1,11 +1,12 @@
 package plugins
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -13,55 +14,113 @@ import (
 )
 
 var urlRegexpBc = regexp.MustCompile(`bandcamp\.com`)
-var trackinfoRegexp = regexp.MustCompile(`trackinfo: \[({.*})\]`)
+
+// the array after it is decoded as json, a regexp cannot tell where it ends
+var trackinfoRegexp = regexp.MustCompile(`trackinfo\s*:\s*\[`)
+var artistRegexp = regexp.MustCompile(`artist: "(.*?)"`)
+
+const sourceBc = "Bandcamp"
+
+type bandcampTrack struct {
+	Title     string
+	Duration  float64
+	TitleLink string `json:"title_link"`
+	File      struct {
+		URL string `json:"mp3-128"`
+	}
+}
 
 type Bandcamp struct{}
 
-func (bc Bandcamp) CanHandle(arg string) bool {
+func (bc Bandcamp) CanHandle(ctx context.Context, arg string) bool {
 	url, err := url.Parse(arg)
 	return err == nil && url.IsAbs() && urlRegexpBc.MatchString(url.Hostname())
 }
 
-func (bc Bandcamp) Resolve(arg string) (md Metadata, err error) {
-	resp, err := http.Get(arg)
+// Resolve the first playable track of an album.
+func (bc Bandcamp) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	tracks, err := bc.ResolvePlaylist(ctx, arg)
 	if err != nil {
 		return
 	}
+	return tracks[0], nil
+}
+
+// ResolvePlaylist resolves each playable track of an album, or just the one track of a track page.
+func (bc Bandcamp) ResolvePlaylist(ctx context.Context, arg string) ([]Metadata, error) {
+	page, err := url.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := getContext(ctx, http.DefaultClient, arg)
+	if err != nil {
+		return nil, err
+	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	matches := trackinfoRegexp.FindSubmatch(body)
-	if matches == nil || len(matches[1]) == 0 {
-		err = errors.New("could not find track info")
-		return
+	loc := trackinfoRegexp.FindIndex(body)
+	if loc == nil {
+		return nil, errors.New("could not find track info")
+	}
+
+	var trackinfoJson []bandcampTrack
+	// from the opening bracket, the decoder stops at the end of the array
+	err = json.NewDecoder(bytes.NewReader(body[loc[1]-1:])).Decode(&trackinfoJson)
+	if err != nil {
+		return nil, err
 	}
 
-	var trackinfoJson struct {
-		Title    string
-		Duration float64
-		File     struct {
-			URL string `json:"mp3-128"`
+	artist := ""
+	if matches := artistRegexp.FindSubmatch(body); matches != nil {
+		artist = string(matches[1])
+	}
+
+	var tracks []Metadata
+	for _, track := range trackinfoJson {
+		// tracks that are not available to stream have no file
+		if track.File.URL == "" {
+			continue
 		}
+		tracks = append(tracks, track.Metadata(page, artist))
 	}
-	err = json.Unmarshal(matches[1], &trackinfoJson)
-	if err != nil {
-		return
+	if len(tracks) == 0 {
+		return nil, errors.New("no playable tracks")
 	}
+	return tracks, nil
+}
 
-	log.Printf("track info %#v", trackinfoJson)
+func (bct bandcampTrack) Metadata(page *url.URL, artist string) Metadata {
+	// bandcamp links to tracks relative to the page
+	trackURL := page.String()
+	if link, err := url.Parse(bct.TitleLink); err == nil && bct.TitleLink != "" {
+		trackURL = page.ResolveReference(link).String()
+	}
+	fileURL := bct.File.URL
 	// bandcamp reports duration in seconds
-	dur := time.Duration(int(trackinfoJson.Duration*1000)) * time.Millisecond
-	md = Metadata{
-		Title:    trackinfoJson.Title,
+	dur := time.Duration(int(bct.Duration*1000)) * time.Millisecond
+	return Metadata{
+		Title:    bct.Title,
 		Duration: dur,
-		OpenFunc: func() (io.ReadCloser, error) {
-			resp, err := http.Get(trackinfoJson.File.URL)
-			return resp.Body, err
+		OpenFunc: func(ctx context.Context) (io.ReadCloser, error) {
+			resp, err := getContext(ctx, http.DefaultClient, fileURL)
+			if err != nil {
+				return nil, err
+			}
+			// e.g. an error page once the signed url expires
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				resp.Body.Close()
+				return nil, errors.New(resp.Status)
+			}
+			return resp.Body, nil
 		},
+		URL:      trackURL,
+		Source:   sourceBc,
+		Uploader: artist,
 	}
-	return
 }
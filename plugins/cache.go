@@ -0,0 +1,281 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStore persists what a Cache remembers.
+type CacheStore interface {
+	// GetResolved returns nil if nothing is stored for key.
+	GetResolved(key string) ([]byte, error)
+	PutResolved(key string, value []byte) error
+	DeleteResolved(key string) error
+	// SweepResolved deletes the values stored under keys that begin with prefix for which expired is true.
+	SweepResolved(prefix string, expired func(value []byte) bool) error
+}
+
+// Cache remembers what a plugin resolves, so that queueing the same thing again does not have to ask the plugin again.
+// Livestreams and playlists of more than one track are not remembered.
+// Cache is safe to use in multiple goroutines.
+type Cache struct {
+	Plugin
+	// Name distinguishes what this cache remembers from what other caches in the same store remember.
+	Name string
+	// TTL is how long to remember titles, durations, and such.
+	TTL time.Duration
+	// StreamTTL is how long a resolved stream can be opened again before the plugin has to resolve it again,
+	// e.g. because its url is signed and expires.
+	// Values less than or equal to 0 never expire.
+	StreamTTL time.Duration
+	Store     CacheStore
+
+	hits   uint64
+	misses uint64
+
+	mu sync.Mutex
+	// streams resolved by this process, which can be opened without asking the plugin again
+	streams map[string]cachedStream
+	// when the store was last swept of what expired, see sweep
+	swept time.Time
+}
+
+type cachedStream struct {
+	open     func(context.Context) (io.ReadCloser, error)
+	resolved time.Time
+}
+
+// cachedMetadata is the part of Metadata that can be stored.
+type cachedMetadata struct {
+	Title     string        `json:"title"`
+	Duration  time.Duration `json:"duration"`
+	URL       string        `json:"url"`
+	Source    string        `json:"source"`
+	Thumbnail string        `json:"thumbnail"`
+	Uploader  string        `json:"uploader"`
+	Start     time.Duration `json:"start"`
+	Resolved  time.Time     `json:"resolved"`
+}
+
+// NewCache wraps a plugin.
+func NewCache(name string, pl Plugin, ttl time.Duration, streamTTL time.Duration, store CacheStore) *Cache {
+	return &Cache{
+		Plugin:    pl,
+		Name:      name,
+		TTL:       ttl,
+		StreamTTL: streamTTL,
+		Store:     store,
+		streams:   make(map[string]cachedStream),
+	}
+}
+
+// Stats are how many resolutions were remembered and how many had to ask the plugin.
+func (c *Cache) Stats() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+func (c *Cache) Resolve(ctx context.Context, arg string) (Metadata, error) {
+	key := c.key(arg)
+	if md, ok := c.get(key, arg); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return md, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	md, err := c.Plugin.Resolve(ctx, arg)
+	if err != nil {
+		return md, err
+	}
+	c.put(key, md)
+	return md, nil
+}
+
+// ResolvePlaylist remembers links to single tracks on services that also have playlists,
+// but always asks the plugin about playlists of more than one track.
+func (c *Cache) ResolvePlaylist(ctx context.Context, arg string) ([]Metadata, error) {
+	pr, ok := c.Plugin.(PlaylistResolver)
+	if !ok {
+		md, err := c.Resolve(ctx, arg)
+		if err != nil {
+			return nil, err
+		}
+		return []Metadata{md}, nil
+	}
+
+	key := c.key(arg)
+	if md, ok := c.get(key, arg); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return []Metadata{md}, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	tracks, err := pr.ResolvePlaylist(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 1 {
+		c.put(key, tracks[0])
+	}
+	return tracks, nil
+}
+
+func (c *Cache) key(arg string) string {
+	return c.Name + "\x00" + normalize(arg)
+}
+
+func (c *Cache) get(key string, arg string) (md Metadata, ok bool) {
+	value, err := c.Store.GetResolved(key)
+	if err != nil || value == nil {
+		return
+	}
+	var cached cachedMetadata
+	if err := json.Unmarshal(value, &cached); err != nil || time.Since(cached.Resolved) > c.TTL {
+		c.Store.DeleteResolved(key)
+		return
+	}
+	md = Metadata{
+		Title:     cached.Title,
+		Duration:  cached.Duration,
+		OpenFunc:  c.opener(key, arg),
+		URL:       cached.URL,
+		Source:    cached.Source,
+		Thumbnail: cached.Thumbnail,
+		Uploader:  cached.Uploader,
+		Start:     cached.Start,
+	}
+	return md, true
+}
+
+func (c *Cache) put(key string, md Metadata) {
+	// livestreams change what they are about
+	if md.Live {
+		return
+	}
+	now := time.Now()
+	value, err := json.Marshal(cachedMetadata{
+		Title:     md.Title,
+		Duration:  md.Duration,
+		URL:       md.URL,
+		Source:    md.Source,
+		Thumbnail: md.Thumbnail,
+		Uploader:  md.Uploader,
+		Start:     md.Start,
+		Resolved:  now,
+	})
+	if err != nil {
+		return
+	}
+	c.Store.PutResolved(key, value)
+	c.putStream(key, md.OpenFunc, now)
+	c.sweep()
+}
+
+// sweep deletes what expired from the store, at most once per TTL,
+// so that things that are never asked for again do not stay in the store forever.
+func (c *Cache) sweep() {
+	c.mu.Lock()
+	due := time.Since(c.swept) >= c.TTL
+	if due {
+		c.swept = time.Now()
+	}
+	c.mu.Unlock()
+	if !due {
+		return
+	}
+	go func() {
+		err := c.Store.SweepResolved(c.Name+"\x00", func(value []byte) bool {
+			var cached cachedMetadata
+			return json.Unmarshal(value, &cached) != nil || time.Since(cached.Resolved) > c.TTL
+		})
+		if err != nil {
+			log.Printf("failed to sweep %v cache %v", c.Name, err)
+		}
+	}()
+}
+
+// opener reuses a stream resolved by this process if it has not expired,
+// and otherwise resolves the stream again when it is opened.
+func (c *Cache) opener(key string, arg string) func(context.Context) (io.ReadCloser, error) {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		c.mu.Lock()
+		stream, ok := c.streams[key]
+		c.mu.Unlock()
+		if ok && !c.streamExpired(stream) {
+			return stream.open(ctx)
+		}
+
+		var md Metadata
+		var err error
+		if pr, ok := c.Plugin.(PlaylistResolver); ok {
+			var tracks []Metadata
+			tracks, err = pr.ResolvePlaylist(ctx, arg)
+			if err == nil {
+				md = tracks[0]
+			}
+		} else {
+			md, err = c.Plugin.Resolve(ctx, arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.putStream(key, md.OpenFunc, time.Now())
+		return md.OpenFunc(ctx)
+	}
+}
+
+func (c *Cache) putStream(key string, open func(context.Context) (io.ReadCloser, error), resolved time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, stream := range c.streams {
+		if c.streamExpired(stream) {
+			delete(c.streams, k)
+		}
+	}
+	c.streams[key] = cachedStream{open: open, resolved: resolved}
+}
+
+func (c *Cache) streamExpired(stream cachedStream) bool {
+	ttl := c.StreamTTL
+	if ttl <= 0 || ttl > c.TTL {
+		ttl = c.TTL
+	}
+	return time.Since(stream.resolved) > ttl
+}
+
+// normalize makes different ways of writing the same input look the same,
+// e.g. by ignoring the order of url parameters, tracking parameters, and the case of search terms.
+func normalize(arg string) string {
+	arg = strings.TrimSpace(arg)
+	u, err := url.Parse(arg)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return strings.Join(strings.Fields(strings.ToLower(arg)), " ")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	for _, prefix := range []string{"www.", "m."} {
+		u.Host = strings.TrimPrefix(u.Host, prefix)
+	}
+	query := u.Query()
+	// a timestamp in the fragment means the same as one in the query
+	if fragment, err := url.ParseQuery(u.Fragment); err == nil && fragment.Get("t") != "" {
+		query.Set("t", fragment.Get("t"))
+	}
+	u.Fragment = ""
+	for param := range query {
+		if strings.HasPrefix(param, "utm_") || param == "feature" || param == "si" {
+			query.Del(param)
+		}
+	}
+	// Encode sorts by key
+	u.RawQuery = query.Encode()
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String()
+}
@@ -0,0 +1,220 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memCacheStore is a CacheStore in memory.
+type memCacheStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func newMemCacheStore() *memCacheStore {
+	return &memCacheStore{values: make(map[string][]byte)}
+}
+
+func (s *memCacheStore) GetResolved(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key], nil
+}
+
+func (s *memCacheStore) PutResolved(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+func (s *memCacheStore) DeleteResolved(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memCacheStore) SweepResolved(prefix string, expired func(value []byte) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, value := range s.values {
+		if strings.HasPrefix(key, prefix) && expired(value) {
+			delete(s.values, key)
+		}
+	}
+	return nil
+}
+
+// age makes what is stored for key look like it was resolved d ago.
+func (s *memCacheStore) age(t *testing.T, key string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cached cachedMetadata
+	if err := json.Unmarshal(s.values[key], &cached); err != nil {
+		t.Fatalf("nothing stored for %q %v", key, err)
+	}
+	cached.Resolved = cached.Resolved.Add(-d)
+	s.values[key], _ = json.Marshal(cached)
+}
+
+// countingPlugin resolves anything, and its streams read which resolution they came from.
+type countingPlugin struct {
+	live     bool
+	resolves int32
+}
+
+func (cp *countingPlugin) CanHandle(ctx context.Context, arg string) bool {
+	return true
+}
+
+func (cp *countingPlugin) Resolve(ctx context.Context, arg string) (Metadata, error) {
+	n := strconv.Itoa(int(atomic.AddInt32(&cp.resolves, 1)))
+	return Metadata{
+		Title:    arg,
+		Duration: time.Minute,
+		Live:     cp.live,
+		URL:      arg,
+		OpenFunc: func(context.Context) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(n)), nil
+		},
+	}, nil
+}
+
+func readStream(t *testing.T, md Metadata) string {
+	rc, err := md.OpenFunc(context.Background())
+	if err != nil {
+		t.Fatalf("OpenFunc failed %v", err)
+	}
+	defer rc.Close()
+	b, _ := ioutil.ReadAll(rc)
+	return string(b)
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"https://www.youtube.com/watch?v=abc&list=xyz", "https://youtube.com/watch?list=xyz&v=abc"},
+		{"https://m.youtube.com/watch?v=abc", "https://youtube.com/watch?v=abc"},
+		{"https://youtu.be/abc?si=tracking", "https://youtu.be/abc"},
+		{"https://youtube.com/watch?v=abc&feature=share&utm_source=x&utm_medium=y", "https://youtube.com/watch?v=abc"},
+		{"HTTPS://SoundCloud.com/artist/song/", "https://soundcloud.com/artist/song"},
+		{"https://soundcloud.com/artist/song#t=1:23", "https://soundcloud.com/artist/song?t=1:23"},
+		{"  Some   Search Terms ", "some search terms"},
+	}
+	for _, test := range tests {
+		if a, b := normalize(test.a), normalize(test.b); a != b {
+			t.Errorf("normalize(%q) = %q, normalize(%q) = %q", test.a, a, test.b, b)
+		}
+	}
+
+	different := []struct {
+		a, b string
+	}{
+		{"https://youtube.com/watch?v=abc", "https://youtube.com/watch?v=abd"},
+		{"https://youtube.com/watch?v=abc&t=10", "https://youtube.com/watch?v=abc&t=20"},
+		// only timestamps are moved out of the fragment
+		{"https://example.com/a#b", "https://example.com/a?b="},
+	}
+	for _, test := range different {
+		if a, b := normalize(test.a), normalize(test.b); a == b {
+			t.Errorf("normalize(%q) = normalize(%q) = %q", test.a, test.b, a)
+		}
+	}
+}
+
+func TestCacheResolve(t *testing.T) {
+	store := newMemCacheStore()
+	pl := &countingPlugin{}
+	c := NewCache("test", pl, time.Hour, time.Hour, store)
+
+	md, err := c.Resolve(context.Background(), "https://www.example.com/a?x=1&y=2")
+	if err != nil {
+		t.Fatalf("Resolve failed %v", err)
+	}
+	cached, err := c.Resolve(context.Background(), "https://example.com/a?y=2&x=1")
+	if err != nil {
+		t.Fatalf("Resolve failed %v", err)
+	}
+	if pl.resolves != 1 {
+		t.Errorf("plugin resolved %d times, want 1", pl.resolves)
+	}
+	if cached.Title != md.Title || cached.Duration != md.Duration || cached.URL != md.URL {
+		t.Errorf("Resolve = %+v, want %+v", cached, md)
+	}
+	// the stream resolved the first time is reused
+	if got := readStream(t, cached); got != "1" {
+		t.Errorf("stream from resolution %v, want 1", got)
+	}
+	if hits, misses := c.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("Stats = %d hits %d misses, want 1 1", hits, misses)
+	}
+}
+
+func TestCacheLive(t *testing.T) {
+	pl := &countingPlugin{live: true}
+	c := NewCache("test", pl, time.Hour, time.Hour, newMemCacheStore())
+	for i := 0; i < 2; i++ {
+		if _, err := c.Resolve(context.Background(), "https://example.com/live"); err != nil {
+			t.Fatalf("Resolve failed %v", err)
+		}
+	}
+	if pl.resolves != 2 {
+		t.Errorf("plugin resolved %d times, want 2", pl.resolves)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	store := newMemCacheStore()
+	pl := &countingPlugin{}
+	c := NewCache("test", pl, time.Hour, time.Hour, store)
+	arg := "https://example.com/a"
+
+	c.Resolve(context.Background(), arg)
+	store.age(t, c.key(arg), 2*time.Hour)
+	if _, err := c.Resolve(context.Background(), arg); err != nil {
+		t.Fatalf("Resolve failed %v", err)
+	}
+	if pl.resolves != 2 {
+		t.Errorf("plugin resolved %d times after the ttl, want 2", pl.resolves)
+	}
+}
+
+func TestCacheStreamTTL(t *testing.T) {
+	store := newMemCacheStore()
+	pl := &countingPlugin{}
+	c := NewCache("test", pl, time.Hour, time.Minute, store)
+	arg := "https://example.com/a"
+
+	c.Resolve(context.Background(), arg)
+	// the title is still good, but the stream has to be resolved again
+	c.mu.Lock()
+	stream := c.streams[c.key(arg)]
+	stream.resolved = stream.resolved.Add(-2 * time.Minute)
+	c.streams[c.key(arg)] = stream
+	c.mu.Unlock()
+
+	md, err := c.Resolve(context.Background(), arg)
+	if err != nil {
+		t.Fatalf("Resolve failed %v", err)
+	}
+	if pl.resolves != 1 {
+		t.Errorf("plugin resolved %d times before opening, want 1", pl.resolves)
+	}
+	if got := readStream(t, md); got != "2" {
+		t.Errorf("stream from resolution %v, want 2", got)
+	}
+	// and is reused after that
+	if got := readStream(t, md); got != "2" {
+		t.Errorf("stream from resolution %v, want 2", got)
+	}
+}
@@ -0,0 +1,323 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// how much of a file to read when looking for tags
+const probeLength = 64 * 1024
+
+var audioExtensions = []string{".mp3", ".ogg", ".oga", ".opus", ".flac", ".m4a", ".aac", ".wav"}
+
+// HTTPAudio plays audio files and internet radio streams served directly over http.
+// It should be considered before Streamlink, which does not understand plain audio.
+type HTTPAudio struct {
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+func (ha HTTPAudio) client() *http.Client {
+	if ha.Client == nil {
+		return http.DefaultClient
+	}
+	return ha.Client
+}
+
+// CanHandle http urls.
+// Urls that do not look like audio files are only played if they turn out to respond with audio, see Resolve.
+func (ha HTTPAudio) CanHandle(ctx context.Context, arg string) bool {
+	url, err := url.Parse(arg)
+	return err == nil && url.IsAbs() && (url.Scheme == "http" || url.Scheme == "https")
+}
+
+// Resolve returns ErrNotHandled for urls that do not look like audio files and do not respond with audio,
+// so that a plugin like Streamlink can try them.
+func (ha HTTPAudio) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	md = Metadata{
+		Title:    titleFromURL(arg),
+		OpenFunc: ha.opener(arg),
+		URL:      arg,
+		Source:   "HTTP",
+	}
+	audioFile := hasAudioExtension(arg)
+
+	resp, err := ha.get(ctx, arg, true)
+	if err != nil {
+		if !audioFile {
+			err = ErrNotHandled
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	// internet radio
+	if metaint, _ := strconv.Atoi(resp.Header.Get("icy-metaint")); metaint > 0 {
+		station := resp.Header.Get("icy-name")
+		if station == "" {
+			station = titleFromURL(arg)
+		}
+		md.Title = station
+		md.Uploader = station
+		md.Live = true
+		md.OpenFunc, md.StreamTitle = ha.icyOpener(arg)
+		return
+	}
+
+	head, err := ioutil.ReadAll(io.LimitReader(resp.Body, probeLength))
+	if err != nil {
+		return
+	}
+	if sniffAudio(head) == "" && !isAudioContentType(resp.Header.Get("Content-Type")) {
+		err = ErrNotHandled
+		if audioFile {
+			err = errors.New("not an audio file")
+		}
+		return
+	}
+
+	tags := readTags(head, contentLength(resp))
+	if tags.title != "" {
+		md.Title = joinTitle(tags.artist, tags.title, arg)
+	}
+	md.Uploader = tags.artist
+	md.Duration = tags.duration
+	return
+}
+
+func hasAudioExtension(arg string) bool {
+	url, err := url.Parse(arg)
+	if err != nil {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(url.Path))
+	for _, v := range audioExtensions {
+		if ext == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (ha HTTPAudio) opener(arg string) func(context.Context) (io.ReadCloser, error) {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		resp, err := getContext(ctx, ha.client(), arg)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.New(resp.Status)
+		}
+		return resp.Body, nil
+	}
+}
+
+// icyOpener opens an internet radio stream with its metadata removed.
+// The returned func reports the most recent StreamTitle in the metadata.
+func (ha HTTPAudio) icyOpener(arg string) (func(context.Context) (io.ReadCloser, error), func() string) {
+	var mu sync.Mutex
+	title := ""
+	setTitle := func(t string) {
+		mu.Lock()
+		title = t
+		mu.Unlock()
+	}
+	getTitle := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return title
+	}
+
+	open := func(ctx context.Context) (io.ReadCloser, error) {
+		req, err := http.NewRequest(http.MethodGet, arg, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Icy-MetaData", "1")
+		resp, err := ha.client().Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.New(resp.Status)
+		}
+		metaint, _ := strconv.Atoi(resp.Header.Get("icy-metaint"))
+		if metaint <= 0 {
+			return resp.Body, nil
+		}
+		return &icyReader{
+			ReadCloser: resp.Body,
+			metaint:    metaint,
+			remaining:  metaint,
+			onTitle:    setTitle,
+		}, nil
+	}
+	return open, getTitle
+}
+
+// icyReader removes the metadata blocks that an internet radio stream puts after every metaint bytes of audio.
+type icyReader struct {
+	io.ReadCloser
+	metaint   int
+	remaining int
+	onTitle   func(string)
+}
+
+func (r *icyReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		meta, err := readIcyMetadata(r.ReadCloser)
+		if err != nil {
+			return 0, err
+		}
+		// most blocks are empty, meaning nothing changed
+		if meta != "" {
+			r.onTitle(icyStreamTitle(meta))
+		}
+		r.remaining = r.metaint
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+// get asks for the beginning of a file, and if icy is true, for internet radio metadata.
+// Live streams ignore the range and keep sending, so the caller should close the body early.
+func (ha HTTPAudio) get(ctx context.Context, arg string, icy bool) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, arg, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", probeLength-1))
+	if icy {
+		req.Header.Set("Icy-MetaData", "1")
+	}
+	resp, err := ha.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, errors.New(resp.Status)
+	}
+	return resp, nil
+}
+
+// contentLength is the size of the whole file, even if the response is only part of it.
+// Returns 0 if the size is unknown.
+func contentLength(resp *http.Response) int64 {
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		if idx := strings.LastIndex(cr, "/"); idx >= 0 {
+			if total, err := strconv.ParseInt(cr[idx+1:], 10, 64); err == nil {
+				return total
+			}
+		}
+		return 0
+	}
+	if resp.ContentLength > 0 {
+		return resp.ContentLength
+	}
+	return 0
+}
+
+func isAudioContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.HasPrefix(contentType, "audio/") ||
+		strings.HasPrefix(contentType, "application/ogg") ||
+		strings.HasPrefix(contentType, "application/x-flac")
+}
+
+// sniffAudio names the format of audio that begins with head, or is empty if head does not look like audio.
+func sniffAudio(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return "mp3"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return "ogg"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "flac"
+	case len(head) >= 12 && bytes.HasPrefix(head, []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		return "wav"
+	case len(head) >= 8 && bytes.Equal(head[4:8], []byte("ftyp")):
+		return "mp4"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xF6 == 0xF0:
+		return "aac"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		return "mp3"
+	}
+	return ""
+}
+
+// readIcyMetadata reads one metadata block from an internet radio stream.
+// The block begins with its length in multiples of 16 bytes.
+func readIcyMetadata(r io.Reader) (string, error) {
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", err
+	}
+	meta := make([]byte, int(length[0])*16)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(meta, "\x00")), nil
+}
+
+// icyStreamTitle finds the StreamTitle in metadata like StreamTitle='Artist - Song';
+func icyStreamTitle(meta string) string {
+	const key = "StreamTitle='"
+	start := strings.Index(meta, key)
+	if start < 0 {
+		return ""
+	}
+	meta = meta[start+len(key):]
+	end := strings.Index(meta, "';")
+	if end < 0 {
+		end = strings.LastIndex(meta, "'")
+	}
+	if end < 0 {
+		return meta
+	}
+	return meta[:end]
+}
+
+// joinTitle is "prefix - title", or the file name in the url if there is no title
+func joinTitle(prefix string, title string, arg string) string {
+	switch {
+	case prefix != "" && title != "":
+		return prefix + " - " + title
+	case title != "":
+		return title
+	case prefix != "":
+		return prefix
+	}
+	return titleFromURL(arg)
+}
+
+func titleFromURL(arg string) string {
+	parsed, err := url.Parse(arg)
+	if err != nil {
+		return arg
+	}
+	name := path.Base(parsed.Path)
+	if name == "/" || name == "." {
+		return arg
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
@@ -0,0 +1,180 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// id3Tag is an ID3v2.3 tag with UTF-8 text frames.
+func id3Tag(frames map[string]string) []byte {
+	var body []byte
+	for _, id := range []string{"TIT2", "TPE1", "TALB"} {
+		text, ok := frames[id]
+		if !ok {
+			continue
+		}
+		frame := append([]byte{3}, text...)
+		header := make([]byte, 10)
+		copy(header, id)
+		binary.BigEndian.PutUint32(header[4:], uint32(len(frame)))
+		body = append(body, header...)
+		body = append(body, frame...)
+	}
+	size := len(body)
+	tag := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(tag, body...)
+}
+
+// mp3File is a tag followed by d of 128kbps MPEG-1 layer III frames.
+func mp3File(tag []byte, d time.Duration) []byte {
+	audio := make([]byte, int64(d/time.Millisecond)*128/8)
+	// sync, MPEG-1 layer III without crc, 128kbps at 44.1kHz
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return append(tag, audio...)
+}
+
+func TestHTTPAudioCanHandleWithoutRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("CanHandle made a request to %v", r.URL)
+	}))
+	defer ts.Close()
+
+	ha := HTTPAudio{Client: ts.Client()}
+	for arg, want := range map[string]bool{
+		ts.URL + "/song.mp3":   true,
+		ts.URL + "/stream":     true,
+		"ftp://example.com/a":  false,
+		"not a url":            false,
+		"/relative/song.ogg":   false,
+		"https://example.com/": true,
+	} {
+		if got := ha.CanHandle(context.Background(), arg); got != want {
+			t.Errorf("CanHandle(%q) = %v, want %v", arg, got, want)
+		}
+	}
+}
+
+func TestHTTPAudioResolveContentType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/aac")
+		// not something sniffAudio knows
+		w.Write(make([]byte, 1024))
+	}))
+	defer ts.Close()
+
+	md, err := HTTPAudio{Client: ts.Client()}.Resolve(context.Background(), ts.URL+"/live/stream")
+	if err != nil {
+		t.Fatalf("Resolve failed %v", err)
+	}
+	// not knowing how long it is does not make it live
+	if md.Title != "stream" || md.Source != "HTTP" || md.OpenFunc == nil || md.Live {
+		t.Errorf("Resolve = %+v", md)
+	}
+}
+
+func TestHTTPAudioResolveTags(t *testing.T) {
+	file := mp3File(id3Tag(map[string]string{"TIT2": "Title", "TPE1": "Artist"}), 10*time.Second)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"range", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "audio/mpeg")
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(file))
+		}},
+		// servers that ignore the range send the whole file
+		{"no range", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", strconv.Itoa(len(file)))
+			w.Write(file)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranged := false
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ranged = r.Header.Get("Range") != ""
+				test.handler(w, r)
+			}))
+			defer ts.Close()
+
+			md, err := HTTPAudio{Client: ts.Client()}.Resolve(context.Background(), ts.URL+"/song.mp3")
+			if err != nil {
+				t.Fatalf("Resolve failed %v", err)
+			}
+			if !ranged {
+				t.Error("Resolve did not ask for a range")
+			}
+			if md.Title != "Artist - Title" || md.Uploader != "Artist" {
+				t.Errorf("Resolve = %q by %q", md.Title, md.Uploader)
+			}
+			if md.Duration != 10*time.Second {
+				t.Errorf("Resolve duration = %v, want %v", md.Duration, 10*time.Second)
+			}
+		})
+	}
+}
+
+func TestHTTPAudioResolveIcy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Icy-MetaData") != "1" {
+			t.Error("Resolve did not ask for icy metadata")
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("icy-metaint", "16000")
+		w.Header().Set("icy-name", "Station")
+		w.Write(make([]byte, 1024))
+	}))
+	defer ts.Close()
+
+	md, err := HTTPAudio{Client: ts.Client()}.Resolve(context.Background(), ts.URL+"/;stream")
+	if err != nil {
+		t.Fatalf("Resolve failed %v", err)
+	}
+	if md.Title != "Station" || md.Duration != 0 || !md.Live || md.StreamTitle == nil {
+		t.Errorf("Resolve = %+v", md)
+	}
+}
+
+func TestHTTPAudioResolveNotAudio(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<!doctype html><html></html>"))
+	}))
+	defer ts.Close()
+
+	ha := HTTPAudio{Client: ts.Client()}
+	// left for other plugins
+	for _, path := range []string{"/page", "/missing"} {
+		if _, err := ha.Resolve(context.Background(), ts.URL+path); err != ErrNotHandled {
+			t.Errorf("Resolve(%v) err = %v, want ErrNotHandled", path, err)
+		}
+	}
+	// nothing else would play it either
+	if _, err := ha.Resolve(context.Background(), ts.URL+"/song.mp3"); err == nil || err == ErrNotHandled {
+		t.Errorf("Resolve(/song.mp3) err = %v, want an error", err)
+	}
+}
+
+func TestReadTagsMP3Duration(t *testing.T) {
+	tag := id3Tag(map[string]string{"TIT2": "Title"})
+	file := mp3File(tag, 3*time.Minute)
+	tags := readTags(file[:probeLength], int64(len(file)))
+	if tags.duration != 3*time.Minute {
+		t.Errorf("readTags duration = %v, want %v", tags.duration, 3*time.Minute)
+	}
+	if tags.title != "Title" {
+		t.Errorf("readTags title = %q", tags.title)
+	}
+}
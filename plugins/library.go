@@ -0,0 +1,210 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const libraryPrefix = "lib:"
+
+const sourceLibrary = "Library"
+
+// Library plays audio files in a directory on the host, e.g. lib:artist song name or lib:path/to/file.mp3.
+// Library is safe to use in multiple goroutines.
+type Library struct {
+	root string
+
+	mu     sync.RWMutex
+	tracks []libraryTrack
+}
+
+type libraryTrack struct {
+	// relative to the library root
+	path string
+	tags audioTags
+	// lowercase words of the path and tags, for searching
+	words map[string]bool
+}
+
+// NewLibrary indexes the audio files in root and its subdirectories.
+func NewLibrary(root string) (*Library, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	lib := &Library{root: root}
+	if _, err := lib.Rescan(); err != nil {
+		return nil, err
+	}
+	return lib, nil
+}
+
+// Rescan rebuilds the index and returns the number of files indexed.
+func (lib *Library) Rescan() (int, error) {
+	var tracks []libraryTrack
+	err := filepath.Walk(lib.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isAudioFile(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(lib.root, path)
+		if err != nil {
+			return err
+		}
+		tags, err := readFileTags(path, info.Size())
+		if err != nil {
+			// one unreadable file should not spoil the whole index
+			return nil
+		}
+		tracks = append(tracks, libraryTrack{
+			path:  filepath.ToSlash(rel),
+			tags:  tags,
+			words: words(strings.Join([]string{rel, tags.title, tags.artist, tags.album}, " ")),
+		})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	lib.mu.Lock()
+	lib.tracks = tracks
+	lib.mu.Unlock()
+	return len(tracks), nil
+}
+
+func (lib *Library) CanHandle(ctx context.Context, arg string) bool {
+	return strings.HasPrefix(arg, libraryPrefix)
+}
+
+// Resolve a path relative to the library root, or else the best search result.
+func (lib *Library) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	query := strings.TrimSpace(strings.TrimPrefix(arg, libraryPrefix))
+
+	lib.mu.RLock()
+	for _, track := range lib.tracks {
+		if track.path == filepath.ToSlash(query) {
+			lib.mu.RUnlock()
+			return lib.metadata(track)
+		}
+	}
+	lib.mu.RUnlock()
+
+	results, err := lib.Search(ctx, query, 1)
+	if err != nil {
+		return
+	}
+	return results[0], nil
+}
+
+// Search the titles, artists, albums, and paths of indexed files.
+func (lib *Library) Search(ctx context.Context, query string, n int) ([]Metadata, error) {
+	query = strings.TrimPrefix(query, libraryPrefix)
+	queryWords := words(query)
+
+	type scored struct {
+		track libraryTrack
+		score float64
+	}
+	var matches []scored
+	lib.mu.RLock()
+	for _, track := range lib.tracks {
+		if score := similarity(queryWords, track.words); score > 0 {
+			matches = append(matches, scored{track, score})
+		}
+	}
+	lib.mu.RUnlock()
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	var results []Metadata
+	for _, match := range matches {
+		md, err := lib.metadata(match.track)
+		if err != nil {
+			continue
+		}
+		results = append(results, md)
+		if len(results) == n {
+			break
+		}
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no results")
+	}
+	return results, nil
+}
+
+func (lib *Library) metadata(track libraryTrack) (md Metadata, err error) {
+	path, err := lib.path(track.path)
+	if err != nil {
+		return
+	}
+	title := track.tags.title
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(track.path), filepath.Ext(track.path))
+	} else if track.tags.artist != "" {
+		title = track.tags.artist + " - " + title
+	}
+	md = Metadata{
+		Title:    title,
+		Duration: track.tags.duration,
+		OpenFunc: func(ctx context.Context) (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+		URL:      libraryPrefix + track.path,
+		Source:   sourceLibrary,
+		Uploader: track.tags.artist,
+	}
+	return
+}
+
+// path refuses to leave the library root, even by following a link.
+func (lib *Library) path(rel string) (string, error) {
+	path := filepath.Join(lib.root, filepath.FromSlash(rel))
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(lib.root)
+	if err != nil {
+		return "", err
+	}
+	inside, err := filepath.Rel(root, resolved)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", errors.New("not in the library")
+	}
+	return resolved, nil
+}
+
+func isAudioFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, v := range audioExtensions {
+		if ext == v {
+			return true
+		}
+	}
+	return false
+}
+
+func readFileTags(path string, size int64) (audioTags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return audioTags{}, err
+	}
+	defer f.Close()
+	head, err := ioutil.ReadAll(io.LimitReader(f, probeLength))
+	if err != nil {
+		return audioTags{}, err
+	}
+	return readTags(head, size), nil
+}
@@ -1,35 +1,91 @@
 package plugins
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"net/url"
 	"os/exec"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Plugin finds something to play for a user's input.
+// Plugins should give up on anything that takes longer than ctx allows.
+// CanHandle should decide from arg alone, plugins that have to look at what arg refers to do so in Resolve.
 type Plugin interface {
-	CanHandle(string) bool
-	Resolve(string) (Metadata, error)
+	CanHandle(ctx context.Context, arg string) bool
+	Resolve(ctx context.Context, arg string) (Metadata, error)
+}
+
+type limitKey struct{}
+
+// WithLimit asks a PlaylistResolver to look up no more than n tracks, e.g. because there is only room for n more.
+// PlaylistResolvers that get a whole playlist at once can ignore the limit.
+func WithLimit(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, limitKey{}, n)
+}
+
+// limit is how many tracks a PlaylistResolver should look up, or 0 for as many as it can.
+func limit(ctx context.Context) int {
+	n, _ := ctx.Value(limitKey{}).(int)
+	return n
+}
+
+// ErrNotHandled is returned by Resolve, or ResolvePlaylist, when a plugin finds out that it cannot handle arg after all,
+// so that the next plugin that can handle arg can try.
+var ErrNotHandled = errors.New("plugins: cannot handle")
+
+// PlaylistResolver resolves a url that refers to several tracks, e.g. a playlist or an album.
+// Tracks are returned in playlist order and have a URL that a Plugin can resolve again.
+type PlaylistResolver interface {
+	ResolvePlaylist(ctx context.Context, arg string) ([]Metadata, error)
+}
+
+// Searcher finds up to n results for a query, most relevant first.
+// Results have a URL that a Plugin can resolve again.
+type Searcher interface {
+	Search(ctx context.Context, query string, n int) ([]Metadata, error)
 }
 
 type Metadata struct {
-	Title    string
+	Title string
+	// Duration is 0 if it is not known, which does not mean the audio is live.
 	Duration time.Duration
-	OpenFunc func() (io.ReadCloser, error)
+	// Live audio has no end and cannot be seeked in, e.g. a livestream or a radio station.
+	Live bool
+	// OpenFunc opens the audio, which stays open until it is closed or ctx is done.
+	OpenFunc func(ctx context.Context) (io.ReadCloser, error)
+	// URL is a link to the audio's page
+	URL string
+	// Source names the service the audio comes from
+	Source    string
+	Thumbnail string
+	Uploader  string
+	// StreamTitle, if not nil, reports what a live stream is playing right now.
+	// It is only meaningful after OpenFunc is called.
+	StreamTitle func() string
+	// Start is where to begin playing, e.g. from a timestamped link.
+	// OpenFunc always opens the audio from the beginning.
+	Start time.Duration
 }
 
 // Streamlink is a generic plugin capable of handling a large variety of urls.
 // It should be considered last in order to prioritize more narrowly focused plugins.
 type Streamlink struct{}
 
-func (sl Streamlink) CanHandle(arg string) bool {
+func (sl Streamlink) CanHandle(ctx context.Context, arg string) bool {
 	// fail fast to avoid launching another process
 	url, err := url.Parse(arg)
 	if err != nil || !url.IsAbs() {
 		return false
 	}
-	streamlink := exec.Command(
+	streamlink := exec.CommandContext(
+		ctx,
 		"streamlink",
 		"--can-handle-url",
 		arg,
@@ -37,10 +93,12 @@ func (sl Streamlink) CanHandle(arg string) bool {
 	return streamlink.Run() == nil
 }
 
-func (sl Streamlink) Resolve(arg string) (md Metadata, err error) {
+func (sl Streamlink) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
 	md = Metadata{
 		Title:    arg,
 		Duration: 0,
+		// what streamlink plays that other plugins do not is mostly livestreams
+		Live: true,
 		// guess at the name of audio only streams that might be available
 		OpenFunc: streamlinkOpener(arg, "audio,audio_only,480p,720p,best"),
 	}
@@ -49,9 +107,10 @@ func (sl Streamlink) Resolve(arg string) (md Metadata, err error) {
 
 // format is comma separated list of stream precedence e.g. "480p,best"
 // "best"/"worst" meta formats are always available
-func streamlinkOpener(url string, format string) func() (io.ReadCloser, error) {
-	return func() (io.ReadCloser, error) {
-		streamlink := exec.Command(
+func streamlinkOpener(url string, format string) func(context.Context) (io.ReadCloser, error) {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		streamlink := exec.CommandContext(
+			ctx,
 			"streamlink",
 			"-O",
 			url,
@@ -81,3 +140,59 @@ func (slrc streamlinkReadCloser) Close() error {
 	log.Printf("closed streamlink %v", err)
 	return err
 }
+
+// ParseTimestamp reads a position in a track written as seconds (90), with units (1m30s), or like a clock (1:30 or 1:02:03).
+func ParseTimestamp(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ":") {
+		parts := strings.Split(s, ":")
+		if len(parts) > 3 {
+			return 0, fmt.Errorf("%q is not a timestamp", s)
+		}
+		var d time.Duration
+		for _, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("%q is not a timestamp", s)
+			}
+			d = d*60 + time.Duration(n)*time.Second
+		}
+		return d, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a timestamp", s)
+	}
+	return d, nil
+}
+
+// startTime is where a timestamped link starts playing, e.g. ?t=90, ?start=90, or #t=1m30s.
+// Links without a timestamp, or with one that cannot be read, start at 0.
+func startTime(u *url.URL) time.Duration {
+	query := u.Query()
+	candidates := []string{query.Get("t"), query.Get("start")}
+	if fragment, err := url.ParseQuery(u.Fragment); err == nil {
+		candidates = append(candidates, fragment.Get("t"))
+	}
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if d, err := ParseTimestamp(c); err == nil {
+			return d
+		}
+	}
+	return 0
+}
+
+// getContext is http.Get, but gives up when ctx is done.
+func getContext(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req.WithContext(ctx))
+}
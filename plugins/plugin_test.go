@@ -0,0 +1,63 @@
+package plugins
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Duration
+		ok   bool
+	}{
+		{"90", 90 * time.Second, true},
+		{" 123 ", 123 * time.Second, true},
+		{"1m30s", 90 * time.Second, true},
+		{"1h2m3s", time.Hour + 2*time.Minute + 3*time.Second, true},
+		{"1:30", 90 * time.Second, true},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second, true},
+		{"0:05", 5 * time.Second, true},
+		{"1:2:3:4", 0, false},
+		{"1:-5", 0, false},
+		{"1:xx", 0, false},
+		{"-10", 0, false},
+		{"-1m", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		got, err := ParseTimestamp(test.s)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("ParseTimestamp(%q) = %v %v, want %v ok %v", test.s, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	tests := []struct {
+		url  string
+		want time.Duration
+	}{
+		{"https://www.youtube.com/watch?v=abc&t=1h2m3s", time.Hour + 2*time.Minute + 3*time.Second},
+		{"https://youtu.be/abc?t=123", 123 * time.Second},
+		{"https://www.youtube.com/watch?v=abc&start=90", 90 * time.Second},
+		{"https://www.youtube.com/watch?v=abc#t=1m30s", 90 * time.Second},
+		{"https://soundcloud.com/artist/song#t=1:23", 83 * time.Second},
+		{"https://www.youtube.com/watch?v=abc", 0},
+		// unreadable timestamps start from the beginning
+		{"https://www.youtube.com/watch?v=abc&t=soon", 0},
+		// the query wins over the fragment
+		{"https://www.youtube.com/watch?v=abc&t=10#t=20", 10 * time.Second},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatalf("url.Parse(%q) failed %v", test.url, err)
+		}
+		if got := startTime(u); got != test.want {
+			t.Errorf("startTime(%q) = %v, want %v", test.url, got, test.want)
+		}
+	}
+}
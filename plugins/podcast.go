@@ -0,0 +1,425 @@
+package plugins
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFeedMaxAge is how long a podcast feed is reused before it is downloaded again.
+const DefaultFeedMaxAge = 10 * time.Minute
+
+// feeds with years of episodes can be large
+const maxFeedLength = 16 * 1024 * 1024
+
+// urls ending in .xml are often something other than a feed, and have to be looked at
+var feedExtensions = []string{".rss", ".atom"}
+
+var feedContentTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/xml",
+	"text/xml",
+}
+
+// Podcast plays episodes of RSS and Atom podcast feeds.
+// The feed url alone plays the latest episode,
+// [feed url] #N plays the episode numbered N,
+// and [feed url] latest N plays the latest N episodes.
+// Podcast is safe to use in multiple goroutines.
+type Podcast struct {
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+	// MaxAge defaults to DefaultFeedMaxAge
+	MaxAge time.Duration
+
+	mu    sync.Mutex
+	feeds map[string]cachedFeed
+}
+
+type cachedFeed struct {
+	feed    podcastFeed
+	fetched time.Time
+}
+
+type podcastFeed struct {
+	title     string
+	thumbnail string
+	// most recent first
+	episodes []podcastEpisode
+}
+
+type podcastEpisode struct {
+	title     string
+	url       string
+	number    int
+	duration  time.Duration
+	published time.Time
+	thumbnail string
+}
+
+func (pc *Podcast) client() *http.Client {
+	if pc.Client == nil {
+		return http.DefaultClient
+	}
+	return pc.Client
+}
+
+func (pc *Podcast) maxAge() time.Duration {
+	if pc.MaxAge <= 0 {
+		return DefaultFeedMaxAge
+	}
+	return pc.MaxAge
+}
+
+// CanHandle http urls, optionally followed by which episodes to play.
+// Urls that do not turn out to be feeds are left for other plugins, see ResolvePlaylist.
+func (pc *Podcast) CanHandle(ctx context.Context, arg string) bool {
+	feedURL, _, _, err := parsePodcastArg(arg)
+	if err != nil {
+		return false
+	}
+	url, err := url.Parse(feedURL)
+	return err == nil && url.IsAbs() && (url.Scheme == "http" || url.Scheme == "https")
+}
+
+// Resolve the first episode chosen by arg.
+func (pc *Podcast) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	episodes, err := pc.ResolvePlaylist(ctx, arg)
+	if err != nil {
+		return
+	}
+	return episodes[0], nil
+}
+
+// ResolvePlaylist resolves the episodes chosen by arg, oldest first so they are queued in the order they were published.
+// Returns ErrNotHandled if arg does not respond with an RSS or Atom feed, unless its url ends like one.
+func (pc *Podcast) ResolvePlaylist(ctx context.Context, arg string) ([]Metadata, error) {
+	feedURL, number, latest, err := parsePodcastArg(arg)
+	if err != nil {
+		return nil, err
+	}
+	feed, err := pc.feed(ctx, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(feed.episodes) == 0 {
+		return nil, errors.New("no episodes")
+	}
+
+	var chosen []podcastEpisode
+	switch {
+	case number > 0:
+		episode, ok := feed.episode(number)
+		if !ok {
+			return nil, fmt.Errorf("no episode #%d", number)
+		}
+		chosen = []podcastEpisode{episode}
+	case latest > 0:
+		if latest > len(feed.episodes) {
+			latest = len(feed.episodes)
+		}
+		for i := latest - 1; i >= 0; i-- {
+			chosen = append(chosen, feed.episodes[i])
+		}
+	default:
+		chosen = feed.episodes[:1]
+	}
+
+	audio := HTTPAudio{Client: pc.Client}
+	tracks := make([]Metadata, len(chosen))
+	for i, episode := range chosen {
+		thumbnail := episode.thumbnail
+		if thumbnail == "" {
+			thumbnail = feed.thumbnail
+		}
+		tracks[i] = Metadata{
+			Title:     episode.title,
+			Duration:  episode.duration,
+			OpenFunc:  audio.opener(episode.url),
+			URL:       episode.url,
+			Source:    "Podcast",
+			Thumbnail: thumbnail,
+			Uploader:  feed.title,
+		}
+	}
+	return tracks, nil
+}
+
+// episode numbered n by the feed, or else the nth episode ever published.
+func (feed podcastFeed) episode(n int) (podcastEpisode, bool) {
+	for _, episode := range feed.episodes {
+		if episode.number == n {
+			return episode, true
+		}
+	}
+	if n <= len(feed.episodes) {
+		return feed.episodes[len(feed.episodes)-n], true
+	}
+	return podcastEpisode{}, false
+}
+
+// feed downloads and parses a feed, or reuses a recent download.
+func (pc *Podcast) feed(ctx context.Context, feedURL string) (podcastFeed, error) {
+	pc.mu.Lock()
+	cached, ok := pc.feeds[feedURL]
+	pc.mu.Unlock()
+	if ok && time.Since(cached.fetched) < pc.maxAge() {
+		return cached.feed, nil
+	}
+
+	feed, err := pc.fetch(ctx, feedURL)
+	if err != nil {
+		return podcastFeed{}, err
+	}
+
+	pc.mu.Lock()
+	if pc.feeds == nil {
+		pc.feeds = make(map[string]cachedFeed)
+	}
+	// forget feeds nobody has asked for in a while
+	for k, v := range pc.feeds {
+		if time.Since(v.fetched) >= pc.maxAge() {
+			delete(pc.feeds, k)
+		}
+	}
+	pc.feeds[feedURL] = cachedFeed{feed: feed, fetched: time.Now()}
+	pc.mu.Unlock()
+	return feed, nil
+}
+
+func (pc *Podcast) fetch(ctx context.Context, feedURL string) (podcastFeed, error) {
+	resp, err := getContext(ctx, pc.client(), feedURL)
+	if err != nil {
+		return podcastFeed{}, err
+	}
+	defer resp.Body.Close()
+	feedFile := hasFeedExtension(feedURL)
+	if resp.StatusCode != http.StatusOK {
+		if feedFile {
+			return podcastFeed{}, errors.New(resp.Status)
+		}
+		return podcastFeed{}, ErrNotHandled
+	}
+
+	// decide from the headers first, so that audio files and radio streams are never read as feeds
+	// anything without a feed extension has to say it is xml and have an rss or atom root
+	contentType := resp.Header.Get("Content-Type")
+	if isStreamResponse(resp.Header) || (!feedFile && !isFeedContentType(contentType)) {
+		if feedFile {
+			return podcastFeed{}, errors.New("not a feed")
+		}
+		return podcastFeed{}, ErrNotHandled
+	}
+	feed, err := parseFeed(io.LimitReader(resp.Body, maxFeedLength))
+	if err != nil && !feedFile {
+		return podcastFeed{}, ErrNotHandled
+	}
+	return feed, err
+}
+
+// isStreamResponse if the response is audio or an icecast/shoutcast stream.
+func isStreamResponse(header http.Header) bool {
+	if isAudioContentType(header.Get("Content-Type")) {
+		return true
+	}
+	for key := range header {
+		if strings.HasPrefix(strings.ToLower(key), "icy-") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFeedExtension(feedURL string) bool {
+	url, err := url.Parse(feedURL)
+	if err != nil {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(url.Path))
+	for _, v := range feedExtensions {
+		if ext == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePodcastArg splits [feed url] #N or [feed url] latest N.
+func parsePodcastArg(arg string) (feedURL string, number int, latest int, err error) {
+	fields := strings.Fields(arg)
+	switch {
+	case len(fields) == 1:
+	case len(fields) == 2 && strings.HasPrefix(fields[1], "#"):
+		number, err = strconv.Atoi(strings.TrimPrefix(fields[1], "#"))
+		if err == nil && number < 1 {
+			err = errors.New("episode numbers start at 1")
+		}
+	case len(fields) == 3 && strings.EqualFold(fields[1], "latest"):
+		latest, err = strconv.Atoi(fields[2])
+		if err == nil && latest < 1 {
+			err = errors.New("latest how many episodes")
+		}
+	default:
+		err = errors.New("not a podcast")
+	}
+	if err != nil {
+		return
+	}
+	feedURL = fields[0]
+	return
+}
+
+// parseFeed reads an RSS or Atom feed, keeping only entries with audio to play.
+func parseFeed(r io.Reader) (podcastFeed, error) {
+	var doc struct {
+		XMLName xml.Name
+		// rss
+		Channel struct {
+			Title string `xml:"title"`
+			Image struct {
+				Href string `xml:"href,attr"`
+			} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+			Items []struct {
+				Title     string `xml:"title"`
+				PubDate   string `xml:"pubDate"`
+				Enclosure struct {
+					URL  string `xml:"url,attr"`
+					Type string `xml:"type,attr"`
+				} `xml:"enclosure"`
+				Duration string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+				Episode  int    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+				Image    struct {
+					Href string `xml:"href,attr"`
+				} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+			} `xml:"item"`
+		} `xml:"channel"`
+		// atom
+		Title   string `xml:"title"`
+		Icon    string `xml:"icon"`
+		Entries []struct {
+			Title     string `xml:"title"`
+			Published string `xml:"published"`
+			Updated   string `xml:"updated"`
+			Links     []struct {
+				Rel  string `xml:"rel,attr"`
+				Href string `xml:"href,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"link"`
+			Duration string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			Episode  int    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+		} `xml:"entry"`
+	}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return podcastFeed{}, err
+	}
+
+	var feed podcastFeed
+	switch doc.XMLName.Local {
+	case "rss":
+		feed.title = doc.Channel.Title
+		feed.thumbnail = doc.Channel.Image.Href
+		for _, item := range doc.Channel.Items {
+			if item.Enclosure.URL == "" || !isEpisodeType(item.Enclosure.Type) {
+				continue
+			}
+			feed.episodes = append(feed.episodes, podcastEpisode{
+				title:     item.Title,
+				url:       item.Enclosure.URL,
+				number:    item.Episode,
+				duration:  parseItunesDuration(item.Duration),
+				published: parseFeedTime(item.PubDate),
+				thumbnail: item.Image.Href,
+			})
+		}
+	case "feed":
+		feed.title = doc.Title
+		feed.thumbnail = doc.Icon
+		for _, entry := range doc.Entries {
+			published := entry.Published
+			if published == "" {
+				published = entry.Updated
+			}
+			for _, link := range entry.Links {
+				if link.Rel != "enclosure" || !isEpisodeType(link.Type) {
+					continue
+				}
+				feed.episodes = append(feed.episodes, podcastEpisode{
+					title:     entry.Title,
+					url:       link.Href,
+					number:    entry.Episode,
+					duration:  parseItunesDuration(entry.Duration),
+					published: parseFeedTime(published),
+				})
+				break
+			}
+		}
+	default:
+		return podcastFeed{}, errors.New("not an rss or atom feed")
+	}
+
+	// feeds are usually newest first, but not always
+	sort.SliceStable(feed.episodes, func(i, j int) bool {
+		return feed.episodes[i].published.After(feed.episodes[j].published)
+	})
+	return feed, nil
+}
+
+// isEpisodeType is true for audio enclosures, or enclosures that do not say what they are
+func isEpisodeType(contentType string) bool {
+	return contentType == "" || isAudioContentType(contentType)
+}
+
+// parseItunesDuration understands HH:MM:SS, MM:SS, and plain seconds.
+// Returns 0 if the duration is missing or malformed.
+func parseItunesDuration(s string) time.Duration {
+	var total float64
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		total = total*60 + n
+	}
+	return time.Duration(total * float64(time.Second))
+}
+
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+}
+
+// parseFeedTime returns the zero time if s is not in a format feeds commonly use.
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func isFeedContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, v := range feedContentTypes {
+		if strings.HasPrefix(contentType, v) {
+			return true
+		}
+	}
+	return false
+}
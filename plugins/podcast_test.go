@@ -0,0 +1,227 @@
+package plugins
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rssFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <channel>
+    <title>Show</title>
+    <itunes:image href="http://a/show.jpg"/>
+    <item>
+      <title>First</title>
+      <pubDate>Mon, 02 Jan 2006 15:04:05 -0700</pubDate>
+      <enclosure url="http://a/1.mp3" type="audio/mpeg"/>
+      <itunes:duration>1:02:03</itunes:duration>
+      <itunes:episode>1</itunes:episode>
+    </item>
+    <item>
+      <title>Third</title>
+      <pubDate>Wed, 04 Jan 2006 15:04:05 -0700</pubDate>
+      <enclosure url="http://a/3.mp3" type="audio/mpeg"/>
+      <itunes:duration>90</itunes:duration>
+      <itunes:image href="http://a/3.jpg"/>
+    </item>
+    <item>
+      <title>Video</title>
+      <pubDate>Thu, 05 Jan 2006 15:04:05 -0700</pubDate>
+      <enclosure url="http://a/4.mp4" type="video/mp4"/>
+    </item>
+    <item>
+      <title>Second</title>
+      <pubDate>Tue, 03 Jan 2006 15:04:05 -0700</pubDate>
+      <enclosure url="http://a/2.mp3"/>
+      <itunes:duration>12:34</itunes:duration>
+    </item>
+  </channel>
+</rss>`
+
+const atomFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <title>Show</title>
+  <icon>http://a/show.png</icon>
+  <entry>
+    <title>First</title>
+    <published>2006-01-02T15:04:05Z</published>
+    <link rel="alternate" href="http://a/1.html"/>
+    <link rel="enclosure" href="http://a/1.ogg" type="audio/ogg"/>
+    <itunes:duration>61</itunes:duration>
+  </entry>
+  <entry>
+    <title>Second</title>
+    <updated>2006-01-03T15:04:05Z</updated>
+    <link rel="enclosure" href="http://a/2.ogg" type="audio/ogg"/>
+  </entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		title     string
+		thumbnail string
+		episodes  []string
+		durations []time.Duration
+	}{
+		// newest first, without the video
+		{"rss", rssFeed, "Show", "http://a/show.jpg",
+			[]string{"http://a/3.mp3", "http://a/2.mp3", "http://a/1.mp3"},
+			[]time.Duration{90 * time.Second, 12*time.Minute + 34*time.Second, time.Hour + 2*time.Minute + 3*time.Second}},
+		{"atom", atomFeed, "Show", "http://a/show.png",
+			[]string{"http://a/2.ogg", "http://a/1.ogg"},
+			[]time.Duration{0, 61 * time.Second}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feed, err := parseFeed(strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("parseFeed failed %v", err)
+			}
+			if feed.title != test.title || feed.thumbnail != test.thumbnail {
+				t.Errorf("parseFeed = %q %q, want %q %q", feed.title, feed.thumbnail, test.title, test.thumbnail)
+			}
+			var episodes []string
+			var durations []time.Duration
+			for _, episode := range feed.episodes {
+				episodes = append(episodes, episode.url)
+				durations = append(durations, episode.duration)
+			}
+			if !reflect.DeepEqual(episodes, test.episodes) {
+				t.Errorf("parseFeed episodes = %q, want %q", episodes, test.episodes)
+			}
+			if !reflect.DeepEqual(durations, test.durations) {
+				t.Errorf("parseFeed durations = %v, want %v", durations, test.durations)
+			}
+		})
+	}
+
+	if _, err := parseFeed(strings.NewReader("<html><body></body></html>")); err == nil {
+		t.Error("parseFeed of html did not fail")
+	}
+}
+
+func TestParseItunesDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"1:02:03":  time.Hour + 2*time.Minute + 3*time.Second,
+		"01:02:03": time.Hour + 2*time.Minute + 3*time.Second,
+		"12:34":    12*time.Minute + 34*time.Second,
+		"90":       90 * time.Second,
+		" 90.5 ":   90*time.Second + 500*time.Millisecond,
+		"":         0,
+		"1:xx":     0,
+		"-5":       0,
+	} {
+		if got := parseItunesDuration(s); got != want {
+			t.Errorf("parseItunesDuration(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestPodcastEpisode(t *testing.T) {
+	// newest first, like parseFeed
+	feed := podcastFeed{episodes: []podcastEpisode{
+		{url: "bonus"},
+		{url: "2", number: 2},
+		{url: "1", number: 1},
+		{url: "unnumbered"},
+	}}
+	tests := []struct {
+		n    int
+		want string
+		ok   bool
+	}{
+		// numbered by the feed
+		{1, "1", true},
+		{2, "2", true},
+		// or counted from the oldest
+		{3, "2", true},
+		{4, "bonus", true},
+		{5, "", false},
+	}
+	for _, test := range tests {
+		episode, ok := feed.episode(test.n)
+		if ok != test.ok || episode.url != test.want {
+			t.Errorf("episode(%d) = %q %v, want %q %v", test.n, episode.url, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestPodcastResolvePlaylist(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		w.Write([]byte(rssFeed))
+	})
+	mux.HandleFunc("/feed.rss", func(w http.ResponseWriter, r *http.Request) {
+		// the extension is enough
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(rssFeed))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<!doctype html><html></html>"))
+	})
+	mux.HandleFunc("/untyped", func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		w.Write([]byte(rssFeed))
+	})
+	// streams never end, and should be given up on from the headers alone
+	stream := func(header map[string]string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			for k, v := range header {
+				w.Header().Set(k, v)
+			}
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}
+	mux.HandleFunc("/song", stream(map[string]string{"Content-Type": "audio/mpeg"}))
+	mux.HandleFunc("/radio", stream(map[string]string{"Content-Type": "text/xml", "icy-name": "Station"}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		arg  string
+		err  error
+		urls []string
+	}{
+		{arg: "/feed", urls: []string{"http://a/3.mp3"}},
+		{arg: "/feed #1", urls: []string{"http://a/1.mp3"}},
+		// oldest first so they are queued in order
+		{arg: "/feed latest 2", urls: []string{"http://a/2.mp3", "http://a/3.mp3"}},
+		{arg: "/feed latest 10", urls: []string{"http://a/1.mp3", "http://a/2.mp3", "http://a/3.mp3"}},
+		{arg: "/feed.rss", urls: []string{"http://a/3.mp3"}},
+		// left for other plugins
+		{arg: "/page", err: ErrNotHandled},
+		{arg: "/untyped", err: ErrNotHandled},
+		{arg: "/missing", err: ErrNotHandled},
+		{arg: "/song", err: ErrNotHandled},
+		{arg: "/radio", err: ErrNotHandled},
+	}
+	for _, test := range tests {
+		t.Run(test.arg, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			pc := &Podcast{Client: ts.Client()}
+			tracks, err := pc.ResolvePlaylist(ctx, ts.URL+test.arg)
+			if err != test.err {
+				t.Fatalf("ResolvePlaylist err = %v, want %v", err, test.err)
+			}
+			var urls []string
+			for _, md := range tracks {
+				urls = append(urls, md.URL)
+			}
+			if !reflect.DeepEqual(urls, test.urls) {
+				t.Errorf("ResolvePlaylist = %q, want %q", urls, test.urls)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package plugins
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// playlist files are small, anything bigger is probably not a playlist
+const maxPlaylistFileLength = 1024 * 1024
+
+var playlistExtensions = []string{".m3u", ".m3u8", ".pls", ".xspf"}
+
+var playlistContentTypes = []string{
+	"audio/x-mpegurl",
+	"audio/mpegurl",
+	"application/x-mpegurl",
+	"application/vnd.apple.mpegurl",
+	"audio/x-scpls",
+	"application/pls+xml",
+	"application/xspf+xml",
+}
+
+// RadioPlaylist plays the entries of M3U, PLS, and XSPF playlists, as used by most internet radio stations.
+// A playlist of streams is treated as a radio station and plays just the first stream that responds.
+// HLS playlists, and urls that turn out not to be playlists, are left to other plugins such as Streamlink.
+type RadioPlaylist struct {
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type playlistEntry struct {
+	url      string
+	title    string
+	creator  string
+	duration time.Duration
+}
+
+func (rp RadioPlaylist) client() *http.Client {
+	if rp.Client == nil {
+		return http.DefaultClient
+	}
+	return rp.Client
+}
+
+// CanHandle any http url, since playlists do not always have a playlist extension.
+func (rp RadioPlaylist) CanHandle(ctx context.Context, arg string) bool {
+	url, err := url.Parse(arg)
+	return err == nil && url.IsAbs() && (url.Scheme == "http" || url.Scheme == "https")
+}
+
+// Resolve the first entry of the playlist, or the radio station.
+func (rp RadioPlaylist) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	tracks, err := rp.ResolvePlaylist(ctx, arg)
+	if err != nil {
+		return
+	}
+	return tracks[0], nil
+}
+
+// ResolvePlaylist resolves each entry of a playlist of tracks,
+// or resolves just the first reachable stream of a radio station.
+// Returns ErrNotHandled for HLS playlists and for urls that do not respond with a playlist.
+func (rp RadioPlaylist) ResolvePlaylist(ctx context.Context, arg string) ([]Metadata, error) {
+	name, entries, err := rp.fetch(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	audio := HTTPAudio{Client: rp.Client}
+	if isRadio(entries) {
+		for _, entry := range entries {
+			md, err := audio.Resolve(ctx, entry.url)
+			if err != nil {
+				log.Printf("radio stream %v unreachable %v", entry.url, err)
+				continue
+			}
+			// the station name in the playlist is usually nicer than the one in the stream
+			switch {
+			case name != "":
+				md.Title = name
+			case entry.title != "":
+				md.Title = entry.title
+			}
+			md.Duration = 0
+			md.Live = true
+			return []Metadata{md}, nil
+		}
+		return nil, errors.New("no reachable streams")
+	}
+
+	tracks := make([]Metadata, 0, len(entries))
+	for _, entry := range entries {
+		title := entry.title
+		if title == "" {
+			title = titleFromURL(entry.url)
+		} else if entry.creator != "" {
+			title = entry.creator + " - " + title
+		}
+		tracks = append(tracks, Metadata{
+			Title:    title,
+			Duration: entry.duration,
+			OpenFunc: audio.opener(entry.url),
+			URL:      entry.url,
+			Source:   "Playlist",
+			Uploader: entry.creator,
+		})
+	}
+	return tracks, nil
+}
+
+// fetch downloads and parses a playlist, returning the name of the playlist if it has one.
+// Only urls with a playlist extension or content type are read.
+func (rp RadioPlaylist) fetch(ctx context.Context, arg string) (name string, entries []playlistEntry, err error) {
+	base, err := url.Parse(arg)
+	if err != nil {
+		return
+	}
+	playlistFile := hasPlaylistExtension(base)
+
+	resp, err := getContext(ctx, rp.client(), arg)
+	if err != nil {
+		if !playlistFile {
+			err = ErrNotHandled
+		}
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New(resp.Status)
+		if !playlistFile {
+			err = ErrNotHandled
+		}
+		return
+	}
+	// e.g. an audio file or a web page, which is up to other plugins
+	if !playlistFile && !isPlaylistContentType(resp.Header.Get("Content-Type")) {
+		err = ErrNotHandled
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPlaylistFileLength))
+	if err != nil {
+		return
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		name, entries, err = parseXSPF(trimmed)
+	case bytes.HasPrefix(bytes.ToLower(trimmed), []byte("[playlist]")):
+		entries, err = parsePLS(trimmed)
+	default:
+		name, entries, err = parseM3U(trimmed)
+	}
+	if err == errHLS {
+		err = ErrNotHandled
+	}
+	if err != nil {
+		return
+	}
+	if len(entries) == 0 {
+		err = errors.New("empty playlist")
+		return
+	}
+
+	// entries can be relative to the playlist
+	for i := range entries {
+		if ref, err := url.Parse(entries[i].url); err == nil {
+			entries[i].url = base.ResolveReference(ref).String()
+		}
+	}
+	return
+}
+
+var errHLS = errors.New("HLS playlists are not supported")
+
+// parseM3U understands the #EXTM3U extensions, but refuses HLS playlists with errHLS.
+func parseM3U(body []byte) (name string, entries []playlistEntry, err error) {
+	next := playlistEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-"):
+			err = errHLS
+			return
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:seconds,title where seconds is -1 for streams
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			idx := strings.Index(info, ",")
+			if idx < 0 {
+				idx = len(info)
+			} else {
+				next.title = strings.TrimSpace(info[idx+1:])
+			}
+			// seconds can be followed by attributes e.g. #EXTINF:-1 tvg-id="x",title
+			fields := strings.Fields(info[:idx])
+			if len(fields) > 0 {
+				if sec, err := strconv.ParseFloat(fields[0], 64); err == nil && sec > 0 {
+					next.duration = time.Duration(sec * float64(time.Second))
+				}
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			next.url = line
+			entries = append(entries, next)
+			next = playlistEntry{}
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// parsePLS reads the FileN, TitleN, and LengthN keys of a PLS playlist.
+func parsePLS(body []byte) ([]playlistEntry, error) {
+	byIndex := make(map[int]*playlistEntry)
+	var order []int
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			continue
+		}
+		key, val := strings.ToLower(line[:idx]), strings.TrimSpace(line[idx+1:])
+
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+			}
+		}
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(key[len(field):])
+		if err != nil {
+			continue
+		}
+		entry, ok := byIndex[n]
+		if !ok {
+			entry = &playlistEntry{}
+			byIndex[n] = entry
+			order = append(order, n)
+		}
+
+		switch field {
+		case "file":
+			entry.url = val
+		case "title":
+			entry.title = val
+		case "length":
+			// length is -1 for streams
+			if sec, err := strconv.Atoi(val); err == nil && sec > 0 {
+				entry.duration = time.Duration(sec) * time.Second
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// entries are numbered, but might not be listed in order
+	sort.Ints(order)
+	entries := make([]playlistEntry, 0, len(order))
+	for _, n := range order {
+		if entry := byIndex[n]; entry.url != "" {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+// parseXSPF reads the location, title, creator, and duration of each track.
+func parseXSPF(body []byte) (name string, entries []playlistEntry, err error) {
+	var doc struct {
+		Title  string `xml:"title"`
+		Tracks []struct {
+			Location []string `xml:"location"`
+			Title    string   `xml:"title"`
+			Creator  string   `xml:"creator"`
+			// milliseconds
+			Duration int64 `xml:"duration"`
+		} `xml:"trackList>track"`
+	}
+	if err = xml.Unmarshal(body, &doc); err != nil {
+		return
+	}
+	name = doc.Title
+	for _, track := range doc.Tracks {
+		if len(track.Location) == 0 {
+			continue
+		}
+		entries = append(entries, playlistEntry{
+			url:      strings.TrimSpace(track.Location[0]),
+			title:    track.Title,
+			creator:  track.Creator,
+			duration: time.Duration(track.Duration) * time.Millisecond,
+		})
+	}
+	return
+}
+
+// isRadio if none of the entries have a duration or look like audio files, which is how stations list their streams
+func isRadio(entries []playlistEntry) bool {
+	for _, entry := range entries {
+		if entry.duration > 0 {
+			return false
+		}
+		if u, err := url.Parse(entry.url); err == nil {
+			ext := strings.ToLower(path.Ext(u.Path))
+			for _, v := range audioExtensions {
+				if ext == v {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func hasPlaylistExtension(u *url.URL) bool {
+	ext := strings.ToLower(path.Ext(u.Path))
+	for _, v := range playlistExtensions {
+		if ext == v {
+			return true
+		}
+	}
+	return false
+}
+
+func isPlaylistContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, v := range playlistContentTypes {
+		if strings.HasPrefix(contentType, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package plugins
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseM3U(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		title   string
+		entries []playlistEntry
+		err     error
+	}{
+		{"plain", "http://a/1.mp3\n\nhttp://a/2.mp3\n", "", []playlistEntry{
+			{url: "http://a/1.mp3"},
+			{url: "http://a/2.mp3"},
+		}, nil},
+		{"extended", "#EXTM3U\n#PLAYLIST:Mix\n#EXTINF:123,Artist - Song\nhttp://a/1.mp3\n" +
+			"#EXTINF:-1 tvg-id=\"x\",Station\nhttp://a/live\n", "Mix", []playlistEntry{
+			{url: "http://a/1.mp3", title: "Artist - Song", duration: 123 * time.Second},
+			{url: "http://a/live", title: "Station"},
+		}, nil},
+		{"hls", "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10,\nsegment0.ts\n", "", nil, errHLS},
+		{"hls variants", "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=128000\nlow/index.m3u8\n", "", nil, errHLS},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			title, entries, err := parseM3U([]byte(test.body))
+			if err != test.err {
+				t.Fatalf("parseM3U err = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if title != test.title {
+				t.Errorf("parseM3U title = %q, want %q", title, test.title)
+			}
+			if !reflect.DeepEqual(entries, test.entries) {
+				t.Errorf("parseM3U = %+v, want %+v", entries, test.entries)
+			}
+		})
+	}
+}
+
+func TestParsePLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		entries []playlistEntry
+	}{
+		{"station", "[playlist]\nFile1=http://a/1\nTitle1=One\nLength1=-1\nFile2=http://a/2\nNumberOfEntries=2\nVersion=2\n", []playlistEntry{
+			{url: "http://a/1", title: "One"},
+			{url: "http://a/2"},
+		}},
+		// entries are numbered, and are played in that order
+		{"out of order", "[playlist]\nFile2=http://a/2.mp3\nLength2=60\nFile1=http://a/1.mp3\nlength1=30\n", []playlistEntry{
+			{url: "http://a/1.mp3", duration: 30 * time.Second},
+			{url: "http://a/2.mp3", duration: time.Minute},
+		}},
+		{"missing file", "[playlist]\nTitle1=One\nFile2=http://a/2\n", []playlistEntry{
+			{url: "http://a/2"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := parsePLS([]byte(test.body))
+			if err != nil {
+				t.Fatalf("parsePLS failed %v", err)
+			}
+			if !reflect.DeepEqual(entries, test.entries) {
+				t.Errorf("parsePLS = %+v, want %+v", entries, test.entries)
+			}
+		})
+	}
+}
+
+func TestParseXSPF(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <title>Mix</title>
+  <trackList>
+    <track>
+      <location> http://a/1.ogg </location>
+      <location>http://b/1.ogg</location>
+      <title>Song</title>
+      <creator>Artist</creator>
+      <duration>90000</duration>
+    </track>
+    <track><title>No location</title></track>
+  </trackList>
+</playlist>`
+	title, entries, err := parseXSPF([]byte(body))
+	if err != nil {
+		t.Fatalf("parseXSPF failed %v", err)
+	}
+	if title != "Mix" {
+		t.Errorf("parseXSPF title = %q, want %q", title, "Mix")
+	}
+	want := []playlistEntry{{url: "http://a/1.ogg", title: "Song", creator: "Artist", duration: 90 * time.Second}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("parseXSPF = %+v, want %+v", entries, want)
+	}
+}
+
+func TestRadioPlaylistCanHandleWithoutRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("CanHandle made a request to %v", r.URL)
+	}))
+	defer ts.Close()
+
+	rp := RadioPlaylist{Client: ts.Client()}
+	for arg, want := range map[string]bool{
+		ts.URL + "/radio.pls":     true,
+		ts.URL + "/index.m3u8":    true,
+		ts.URL + "/listen":        true,
+		"ftp://example.com/a.m3u": false,
+		"/relative/radio.pls":     false,
+	} {
+		if got := rp.CanHandle(context.Background(), arg); got != want {
+			t.Errorf("CanHandle(%q) = %v, want %v", arg, got, want)
+		}
+	}
+}
+
+func TestRadioPlaylistResolvePlaylist(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10,\nsegment0.ts\n"))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<!doctype html><html></html>"))
+	})
+	mux.HandleFunc("/song.mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(make([]byte, 1024))
+	})
+	mux.HandleFunc("/station", func(w http.ResponseWriter, r *http.Request) {
+		// no extension, the content type says what it is
+		w.Header().Set("Content-Type", "audio/x-scpls")
+		w.Write([]byte("[playlist]\nFile1=/down\nFile2=/live\nFile3=/backup\n"))
+	})
+	mux.HandleFunc("/down", http.NotFound)
+	for _, path := range []string{"/live", "/backup"} {
+		path := path
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Header().Set("icy-metaint", "16000")
+			w.Header().Set("icy-name", path)
+			w.Write(make([]byte, 1024))
+		})
+	}
+	mux.HandleFunc("/album.m3u", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("#EXTM3U\n#EXTINF:60,Artist - One\n1.mp3\n#EXTINF:90,Artist - Two\n2.mp3\n"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	rp := RadioPlaylist{Client: ts.Client()}
+	tests := []struct {
+		path   string
+		err    error
+		titles []string
+		urls   []string
+		live   bool
+	}{
+		// left for other plugins
+		{path: "/index.m3u8", err: ErrNotHandled},
+		{path: "/page", err: ErrNotHandled},
+		{path: "/song.mp3", err: ErrNotHandled},
+		{path: "/missing", err: ErrNotHandled},
+		// just the first stream that responds
+		{path: "/station", titles: []string{"/live"}, urls: []string{ts.URL + "/live"}, live: true},
+		{path: "/album.m3u", titles: []string{"Artist - One", "Artist - Two"}, urls: []string{ts.URL + "/1.mp3", ts.URL + "/2.mp3"}},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			tracks, err := rp.ResolvePlaylist(context.Background(), ts.URL+test.path)
+			if err != test.err {
+				t.Fatalf("ResolvePlaylist err = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			var titles, urls []string
+			for _, md := range tracks {
+				titles = append(titles, md.Title)
+				urls = append(urls, md.URL)
+				if md.Live != test.live {
+					t.Errorf("%v Live = %v, want %v", md.Title, md.Live, test.live)
+				}
+			}
+			if !reflect.DeepEqual(titles, test.titles) || !reflect.DeepEqual(urls, test.urls) {
+				t.Errorf("ResolvePlaylist = %q %q, want %q %q", titles, urls, test.titles, test.urls)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Source is a named group of plugins that share a section of the config, e.g. everything for youtube.
+type Source struct {
+	Name    string
+	Plugins []Plugin
+	// Searchers take part in searches for plain text, which are tried after every other plugin.
+	Searchers []Searcher
+	// CacheTTL is how long to remember what the plugins resolve, see Cache.
+	// Values less than or equal to 0 do not remember.
+	CacheTTL time.Duration
+	// StreamTTL is how long a resolved stream stays good, see Cache.
+	StreamTTL time.Duration
+}
+
+// Factory makes a source from its section of the config.
+// config decodes the section into its argument, and leaves its argument alone if there is no section.
+type Factory func(config func(v interface{}) error) (Source, error)
+
+var factories = make(map[string]Factory)
+
+// DefaultOrder is the order that sources are registered, which is the order they are tried when the config does not say otherwise.
+// Sources that recognize their input more cheaply or more certainly are registered first.
+var DefaultOrder []string
+
+// Register makes a source available by name.
+// Register panics if name is already registered.
+func Register(name string, factory Factory) {
+	if _, ok := factories[name]; ok {
+		panic("plugins: source registered twice " + name)
+	}
+	factories[name] = factory
+	DefaultOrder = append(DefaultOrder, name)
+}
+
+// Open makes each named source in order, configured by config.
+// Besides its own settings, every section can set the cachettl and streamttl of its source, e.g. "24h".
+// Sources that cannot be made are logged and left out.
+func Open(names []string, config func(name string, v interface{}) error) []Source {
+	var sources []Source
+	for _, name := range names {
+		factory, ok := factories[name]
+		if !ok {
+			log.Printf("unknown source %v", name)
+			continue
+		}
+		section := func(v interface{}) error {
+			return config(name, v)
+		}
+		src, err := factory(section)
+		if err != nil {
+			log.Printf("failed to open source %v %v", name, err)
+			continue
+		}
+		src.Name = name
+		if err := configureCache(&src, section); err != nil {
+			log.Printf("failed to configure cache for source %v %v", name, err)
+		}
+		sources = append(sources, src)
+	}
+	return sources
+}
+
+func configureCache(src *Source, config func(v interface{}) error) error {
+	var cfg struct {
+		CacheTTL  string
+		StreamTTL string
+	}
+	if err := config(&cfg); err != nil {
+		return err
+	}
+	if cfg.CacheTTL != "" {
+		ttl, err := time.ParseDuration(cfg.CacheTTL)
+		if err != nil {
+			return err
+		}
+		src.CacheTTL = ttl
+	}
+	if cfg.StreamTTL != "" {
+		ttl, err := time.ParseDuration(cfg.StreamTTL)
+		if err != nil {
+			return err
+		}
+		src.StreamTTL = ttl
+	}
+	return nil
+}
+
+// Cached wraps the plugins of each source that has a CacheTTL.
+func Cached(sources []Source, store CacheStore) []Source {
+	cached := make([]Source, len(sources))
+	for i, src := range sources {
+		cached[i] = src
+		if src.CacheTTL <= 0 {
+			continue
+		}
+		cached[i].Plugins = make([]Plugin, len(src.Plugins))
+		for j, pl := range src.Plugins {
+			cached[i].Plugins[j] = NewCache(fmt.Sprintf("%s.%T", src.Name, pl), pl, src.CacheTTL, src.StreamTTL, store)
+		}
+	}
+	return cached
+}
+
+// Plugins are the plugins of each source in order, then a search across every searcher.
+func Plugins(sources []Source) []Plugin {
+	var plugins []Plugin
+	search := SearchMultiple{}
+	for _, src := range sources {
+		plugins = append(plugins, src.Plugins...)
+		search.Resources = append(search.Resources, src.Searchers...)
+	}
+	if len(search.Resources) > 0 {
+		plugins = append(plugins, search)
+	}
+	return plugins
+}
+
+func init() {
+	Register("youtube", openYoutube)
+	Register("soundcloud", openSoundcloud)
+	Register("twitch", openTwitch)
+	Register("bandcamp", openBandcamp)
+	Register("library", openLibrary)
+	Register("radio", openRadio)
+	Register("podcast", openPodcast)
+	Register("http", openHTTP)
+	Register("streamlink", openStreamlink)
+}
+
+func openYoutube(config func(v interface{}) error) (src Source, err error) {
+	var cfg struct {
+		APIKey string
+	}
+	if err = config(&cfg); err != nil {
+		return
+	}
+	if cfg.APIKey != "" {
+		playlist, err := NewYoutubePlaylist(cfg.APIKey)
+		if err != nil {
+			return src, fmt.Errorf("failed to acquire youtube service %v", err)
+		}
+		search, err := NewYoutubeSearch(cfg.APIKey)
+		if err != nil {
+			return src, fmt.Errorf("failed to acquire youtube service %v", err)
+		}
+		// before the youtube plugin, which would only play one video of a playlist
+		src.Plugins = append(src.Plugins, playlist)
+		src.Searchers = append(src.Searchers, search)
+	}
+	src.Plugins = append(src.Plugins, Youtube{})
+	// download urls are signed and expire after a few hours
+	src.CacheTTL, src.StreamTTL = 7*24*time.Hour, time.Hour
+	return
+}
+
+func openSoundcloud(config func(v interface{}) error) (src Source, err error) {
+	var cfg struct {
+		ClientID string
+	}
+	if err = config(&cfg); err != nil {
+		return
+	}
+	src.Plugins = []Plugin{Soundcloud{ClientID: cfg.ClientID}}
+	if cfg.ClientID != "" {
+		src.Searchers = []Searcher{SoundcloudSearch{ClientID: cfg.ClientID}}
+	}
+	src.CacheTTL = 7 * 24 * time.Hour
+	return
+}
+
+func openTwitch(config func(v interface{}) error) (src Source, err error) {
+	var cfg struct {
+		ClientID     string
+		ClientSecret string
+	}
+	if err = config(&cfg); err != nil {
+		return
+	}
+	src.Plugins = []Plugin{&Twitch{ClientID: cfg.ClientID, ClientSecret: cfg.ClientSecret}}
+	return
+}
+
+func openBandcamp(config func(v interface{}) error) (Source, error) {
+	// file urls are signed and expire
+	return Source{Plugins: []Plugin{Bandcamp{}}, CacheTTL: 7 * 24 * time.Hour, StreamTTL: time.Hour}, nil
+}
+
+func openLibrary(config func(v interface{}) error) (src Source, err error) {
+	var cfg struct {
+		Dir string
+	}
+	if err = config(&cfg); err != nil {
+		return
+	}
+	if cfg.Dir == "" {
+		return src, errors.New("no library dir")
+	}
+	lib, err := NewLibrary(cfg.Dir)
+	if err != nil {
+		return
+	}
+	src.Plugins = []Plugin{lib}
+	src.Searchers = []Searcher{lib}
+	return
+}
+
+func openRadio(config func(v interface{}) error) (Source, error) {
+	return Source{Plugins: []Plugin{RadioPlaylist{}}}, nil
+}
+
+func openPodcast(config func(v interface{}) error) (Source, error) {
+	return Source{Plugins: []Plugin{&Podcast{}}}, nil
+}
+
+func openHTTP(config func(v interface{}) error) (Source, error) {
+	return Source{Plugins: []Plugin{HTTPAudio{}}, CacheTTL: 24 * time.Hour}, nil
+}
+
+func openStreamlink(config func(v interface{}) error) (Source, error) {
+	return Source{Plugins: []Plugin{Streamlink{}}}, nil
+}
@@ -1,28 +1,94 @@
 package plugins
 
 import (
+	"context"
 	"errors"
 	"log"
+	"sort"
+	"strings"
+	"unicode"
 )
 
+// SearchMultiple searches each of its Resources and ranks the combined results
+// by how closely their titles match the query.
 type SearchMultiple struct {
-	Resources []Plugin
+	Resources []Searcher
 }
 
-func (sm SearchMultiple) CanHandle(arg string) bool {
-	return arg != ""
+func (sm SearchMultiple) CanHandle(ctx context.Context, arg string) bool {
+	return arg != "" && !httpRegexp.MatchString(arg)
 }
 
-func (sm SearchMultiple) Resolve(arg string) (md Metadata, err error) {
-	// TODO since search plugins may return real but irrelevant results
-	// should evaluate results and return the one most relevant to input arg
-	for _, pl := range sm.Resources {
-		md, err = pl.Resolve(arg)
-		if err == nil {
-			return
+func (sm SearchMultiple) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	results, err := sm.Search(ctx, arg, 1)
+	if err != nil {
+		return
+	}
+	return results[0], nil
+}
+
+// Search asks each resource for up to n results.
+// Results that are equally relevant keep the order of the resources and of each resource's results.
+func (sm SearchMultiple) Search(ctx context.Context, query string, n int) ([]Metadata, error) {
+	var results []Metadata
+	for _, searcher := range sm.Resources {
+		found, err := searcher.Search(ctx, query, n)
+		if err != nil {
+			log.Printf("search failed %v", err)
+			continue
+		}
+		results = append(results, found...)
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no results")
+	}
+
+	queryWords := words(query)
+	scores := make([]float64, len(results))
+	for i, md := range results {
+		scores[i] = similarity(queryWords, words(md.Title))
+	}
+	sort.Stable(byScore{results, scores})
+
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results, nil
+}
+
+type byScore struct {
+	results []Metadata
+	scores  []float64
+}
+
+func (b byScore) Len() int           { return len(b.results) }
+func (b byScore) Less(i, j int) bool { return b.scores[i] > b.scores[j] }
+func (b byScore) Swap(i, j int) {
+	b.results[i], b.results[j] = b.results[j], b.results[i]
+	b.scores[i], b.scores[j] = b.scores[j], b.scores[i]
+}
+
+// words are the lowercase letters and numbers of s, split at anything else
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		set[w] = true
+	}
+	return set
+}
+
+// similarity is the dice coefficient of two sets of words, 1 if they are the same and 0 if they have nothing in common
+func similarity(a map[string]bool, b map[string]bool) float64 {
+	if len(a)+len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range a {
+		if b[w] {
+			common++
 		}
-		log.Printf("search failed %v", err)
 	}
-	err = errors.New("no results")
-	return
+	return 2 * float64(common) / float64(len(a)+len(b))
 }
@@ -1,6 +1,7 @@
 package plugins
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -15,6 +16,8 @@ const endpointScResolve = endpointSc + "resolve/"
 
 var urlRegexpSc = regexp.MustCompile(`soundcloud\.com`)
 
+const sourceSc = "SoundCloud"
+
 type soundcloudTrack struct {
 	Downloadable bool
 	DownloadURL  string `json:"download_url"`
@@ -22,50 +25,92 @@ type soundcloudTrack struct {
 	StreamURL    string `json:"stream_url"`
 	Title        string
 	Duration     int
+	PermalinkURL string `json:"permalink_url"`
+	ArtworkURL   string `json:"artwork_url"`
+	User         struct {
+		Username string
+	}
 }
 
 type Soundcloud struct {
 	ClientID string
 }
 
-func (sc Soundcloud) CanHandle(arg string) bool {
+func (sc Soundcloud) CanHandle(ctx context.Context, arg string) bool {
 	url, err := url.Parse(arg)
 	return err == nil && url.IsAbs() && urlRegexpSc.MatchString(url.Hostname())
 }
 
-func (sc Soundcloud) Resolve(arg string) (md Metadata, err error) {
-	if sc.ClientID == "" {
-		err = errors.New("no soundcloud client id")
+// Resolve the first playable track of a set.
+func (sc Soundcloud) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	tracks, err := sc.ResolvePlaylist(ctx, arg)
+	if err != nil {
 		return
 	}
+	return tracks[0], nil
+}
+
+// ResolvePlaylist resolves each playable track in a set, or just the one track of a track url.
+func (sc Soundcloud) ResolvePlaylist(ctx context.Context, arg string) ([]Metadata, error) {
+	if sc.ClientID == "" {
+		return nil, errors.New("no soundcloud client id")
+	}
 
 	query := url.Values{}
 	query.Add("client_id", sc.ClientID)
 	query.Add("url", arg)
 
-	resp, err := http.Get(endpointScResolve + "?" + query.Encode())
+	resp, err := getContext(ctx, http.DefaultClient, endpointScResolve+"?"+query.Encode())
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		err = errors.New(resp.Status)
-		return
+		return nil, errors.New(resp.Status)
 	}
 	if resp.ContentLength == 0 {
-		err = errors.New("no content")
-		return
+		return nil, errors.New("no content")
 	}
 
-	var track soundcloudTrack
+	// the resolve endpoint responds with whatever kind of resource the url refers to
+	var resource struct {
+		soundcloudTrack
+		Kind   string
+		Tracks []soundcloudTrack
+	}
 	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(&track)
+	err = dec.Decode(&resource)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	return track.Metadata(sc.ClientID)
+	if resource.Kind != "playlist" {
+		md, err := resource.soundcloudTrack.Metadata(sc.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		// links copied at the current position end in #t=1:30
+		if u, err := url.Parse(arg); err == nil {
+			if start := startTime(u); start < md.Duration {
+				md.Start = start
+			}
+		}
+		return []Metadata{md}, nil
+	}
+
+	var tracks []Metadata
+	for _, track := range resource.Tracks {
+		md, err := track.Metadata(sc.ClientID)
+		if err != nil {
+			continue
+		}
+		tracks = append(tracks, md)
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("no playable tracks in set")
+	}
+	return tracks, nil
 }
 
 func (sct soundcloudTrack) Metadata(clientID string) (md Metadata, err error) {
@@ -85,10 +130,21 @@ func (sct soundcloudTrack) Metadata(clientID string) (md Metadata, err error) {
 	md = Metadata{
 		Title:    sct.Title,
 		Duration: time.Duration(sct.Duration) * time.Millisecond,
-		OpenFunc: func() (io.ReadCloser, error) {
-			resp, err := http.Get(dlUrl + "?" + query.Encode())
-			return resp.Body, err
+		OpenFunc: func(ctx context.Context) (io.ReadCloser, error) {
+			resp, err := getContext(ctx, http.DefaultClient, dlUrl+"?"+query.Encode())
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				return nil, errors.New(resp.Status)
+			}
+			return resp.Body, nil
 		},
+		URL:       sct.PermalinkURL,
+		Source:    sourceSc,
+		Thumbnail: sct.ArtworkURL,
+		Uploader:  sct.User.Username,
 	}
 	return
 }
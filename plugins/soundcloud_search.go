@@ -1,10 +1,12 @@
 package plugins
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
+	"strconv"
 )
 
 const endpointScTracks = endpointSc + "tracks/"
@@ -13,42 +15,58 @@ type SoundcloudSearch struct {
 	ClientID string
 }
 
-func (scs SoundcloudSearch) CanHandle(arg string) bool {
+func (scs SoundcloudSearch) CanHandle(ctx context.Context, arg string) bool {
 	return arg != "" && !httpRegexp.MatchString(arg)
 }
 
-func (scs SoundcloudSearch) Resolve(arg string) (md Metadata, err error) {
-	if scs.ClientID == "" {
-		err = errors.New("no soundcloud client id")
+func (scs SoundcloudSearch) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	results, err := scs.Search(ctx, arg, 1)
+	if err != nil {
 		return
 	}
+	return results[0], nil
+}
 
-	query := url.Values{}
-	query.Add("client_id", scs.ClientID)
-	query.Add("q", arg)
+// Search skips tracks that cannot be downloaded or streamed.
+func (scs SoundcloudSearch) Search(ctx context.Context, query string, n int) ([]Metadata, error) {
+	if scs.ClientID == "" {
+		return nil, errors.New("no soundcloud client id")
+	}
+
+	params := url.Values{}
+	params.Add("client_id", scs.ClientID)
+	params.Add("q", query)
+	params.Add("limit", strconv.Itoa(n))
 
-	resp, err := http.Get(endpointScTracks + "?" + query.Encode())
+	resp, err := getContext(ctx, http.DefaultClient, endpointScTracks+"?"+params.Encode())
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		err = errors.New(resp.Status)
-		return
+		return nil, errors.New(resp.Status)
 	}
 
 	var tracks []soundcloudTrack
 	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(&tracks)
-	if err != nil {
-		return
+	if err := dec.Decode(&tracks); err != nil {
+		return nil, err
 	}
 
-	if len(tracks) == 0 {
-		err = errors.New("no results")
-		return
+	var results []Metadata
+	for _, track := range tracks {
+		md, err := track.Metadata(scs.ClientID)
+		if err != nil {
+			continue
+		}
+		results = append(results, md)
+		if len(results) == n {
+			break
+		}
 	}
-
-	return tracks[0].Metadata(scs.ClientID)
+	if len(results) == 0 {
+		return nil, errors.New("no results")
+	}
+	return results, nil
 }
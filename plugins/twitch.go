@@ -1,26 +1,212 @@
 package plugins
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamsURLTw = "https://api.twitch.tv/helix/streams"
+	tokenURLTw   = "https://id.twitch.tv/oauth2/token"
 )
 
 var urlRegexpTw = regexp.MustCompile(`twitch\.tv`)
 
-type Twitch struct{}
+// Twitch plays live channels and videos on twitch.tv with streamlink.
+// Twitch is safe to use in multiple goroutines.
+type Twitch struct {
+	// ClientID and ClientSecret are used to ask the twitch api whether a channel is live.
+	// Without both, every channel is assumed to be live.
+	ClientID     string
+	ClientSecret string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+
+	mu sync.Mutex
+	// app access token, see token
+	accessToken string
+	expires     time.Time
+}
 
-func (tw Twitch) CanHandle(arg string) bool {
+type streamTw struct {
+	UserName     string `json:"user_name"`
+	GameName     string `json:"game_name"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func (tw *Twitch) client() *http.Client {
+	if tw.Client == nil {
+		return http.DefaultClient
+	}
+	return tw.Client
+}
+
+func (tw *Twitch) CanHandle(ctx context.Context, arg string) bool {
 	url, err := url.Parse(arg)
 	return err == nil && url.IsAbs() && urlRegexpTw.MatchString(url.Hostname())
 }
 
-func (tw Twitch) Resolve(arg string) (md Metadata, err error) {
-	// TODO request the twitch api to see if the user is even online and return a pleasant error
-	// also request the twitch api to learn the title of the user's broadcast
+// Resolve refuses channels that are offline.
+// Channels whose status cannot be looked up are assumed to be live.
+func (tw *Twitch) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
 	md = Metadata{
 		Title:    arg,
 		Duration: 0,
 		OpenFunc: streamlinkOpener(arg, "audio_only,480p,720p,best"),
+		URL:      arg,
+		Source:   "Twitch",
+	}
+
+	login := channelTw(arg)
+	md.Live = login != ""
+	// videos and clips are not live and have no stream to look up
+	if tw.ClientID == "" || tw.ClientSecret == "" || login == "" {
+		return
+	}
+	stream, live, lookupErr := tw.stream(ctx, login)
+	if lookupErr != nil {
+		log.Printf("failed to look up twitch channel %v %v", login, lookupErr)
+		return
+	}
+	if !live {
+		err = fmt.Errorf("%s's channel is offline", login)
+		return
+	}
+	md.Title = stream.Title
+	if stream.GameName != "" {
+		md.Title = fmt.Sprintf("%s (%s)", stream.Title, stream.GameName)
+	}
+	md.Uploader = stream.UserName
+	md.Thumbnail = strings.NewReplacer("{width}", "320", "{height}", "180").Replace(stream.ThumbnailURL)
+	return
+}
+
+var errUnauthorizedTw = errors.New("twitch rejected the token")
+
+// stream asks the twitch api about a channel, which is live if it has a stream.
+func (tw *Twitch) stream(ctx context.Context, login string) (stream streamTw, live bool, err error) {
+	token, err := tw.token(ctx)
+	if err != nil {
+		return
+	}
+	stream, live, err = tw.lookup(ctx, login, token)
+	if err == errUnauthorizedTw {
+		// revoked, or expired early
+		tw.forgetToken(token)
+		if token, err = tw.token(ctx); err != nil {
+			return
+		}
+		stream, live, err = tw.lookup(ctx, login, token)
 	}
 	return
 }
+
+func (tw *Twitch) lookup(ctx context.Context, login string, token string) (stream streamTw, live bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, streamsURLTw+"?user_login="+url.QueryEscape(login), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Client-ID", tw.ClientID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := tw.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		err = errUnauthorizedTw
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New(resp.Status)
+		return
+	}
+
+	var body struct {
+		Data []streamTw `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return
+	}
+	// a channel that is offline has no stream
+	if len(body.Data) == 0 || body.Data[0].Type != "live" {
+		return
+	}
+	return body.Data[0], true, nil
+}
+
+// token is an app access token from the client credentials flow, which is reused until shortly before it expires.
+func (tw *Twitch) token(ctx context.Context) (string, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.accessToken != "" && time.Now().Before(tw.expires) {
+		return tw.accessToken, nil
+	}
+
+	form := url.Values{
+		"client_id":     {tw.ClientID},
+		"client_secret": {tw.ClientSecret},
+		"grant_type":    {"client_credentials"},
+	}
+	req, err := http.NewRequest(http.MethodPost, tokenURLTw, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := tw.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get twitch token %v", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.AccessToken == "" {
+		return "", errors.New("twitch did not return a token")
+	}
+	tw.accessToken = body.AccessToken
+	// leave time for requests that are already using the token
+	tw.expires = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - time.Minute)
+	return tw.accessToken, nil
+}
+
+// forgetToken drops a token that the api rejected, unless it has already been replaced.
+func (tw *Twitch) forgetToken(token string) {
+	tw.mu.Lock()
+	if tw.accessToken == token {
+		tw.accessToken = ""
+	}
+	tw.mu.Unlock()
+}
+
+// channelTw finds the channel name in a url like twitch.tv/name, or is empty for urls to videos and clips.
+func channelTw(arg string) string {
+	url, err := url.Parse(arg)
+	if err != nil || strings.HasPrefix(url.Hostname(), "clips.") {
+		return ""
+	}
+	segments := strings.Split(strings.Trim(url.Path, "/"), "/")
+	if len(segments) != 1 || segments[0] == "" || segments[0] == "videos" {
+		return ""
+	}
+	return strings.ToLower(segments[0])
+}
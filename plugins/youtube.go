@@ -1,6 +1,8 @@
 package plugins
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
@@ -11,41 +13,78 @@ import (
 
 var urlRegexpYt = regexp.MustCompile(`youtube\.com|youtu\.be`)
 
+const sourceYt = "YouTube"
+
 type Youtube struct{}
 
-func (yt Youtube) CanHandle(arg string) bool {
+func (yt Youtube) CanHandle(ctx context.Context, arg string) bool {
 	url, err := url.Parse(arg)
 	return err == nil && url.IsAbs() && urlRegexpYt.MatchString(url.Hostname())
 }
 
-func (yt Youtube) Resolve(arg string) (md Metadata, err error) {
-	info, err := ytdl.GetVideoInfo(arg)
+func (yt Youtube) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	info, err := videoInfo(ctx, arg)
 	if err != nil {
 		return
 	}
 
+	md = Metadata{
+		Title:     info.Title,
+		Duration:  info.Duration,
+		URL:       watchURLYt + info.ID,
+		Source:    sourceYt,
+		Uploader:  info.Author,
+		Thumbnail: info.GetThumbnailURL(ytdl.ThumbnailQualityHigh).String(),
+	}
+
 	if info.Livestream {
+		md.Live = true
 		// found that audio_mp4 format always cut out after 2seconds
-		md = Metadata{
-			Title:    info.Title,
-			Duration: info.Duration,
-			OpenFunc: streamlinkOpener(arg, "480p,720p,best"),
-		}
+		md.OpenFunc = streamlinkOpener(md.URL, "480p,720p,best")
 		return
 	}
 
+	if u, err := url.Parse(arg); err == nil {
+		if start := startTime(u); start < md.Duration {
+			md.Start = start
+		}
+	}
+
 	dlUrl, err := info.GetDownloadURL(info.Formats.Extremes(ytdl.FormatAudioEncodingKey, true)[0])
 	if err != nil {
 		return
 	}
 
-	md = Metadata{
-		Title:    info.Title,
-		Duration: info.Duration,
-		OpenFunc: func() (io.ReadCloser, error) {
-			resp, err := http.Get(dlUrl.String())
-			return resp.Body, err
-		},
+	md.OpenFunc = func(ctx context.Context) (io.ReadCloser, error) {
+		resp, err := getContext(ctx, http.DefaultClient, dlUrl.String())
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.New(resp.Status)
+		}
+		return resp.Body, nil
 	}
 	return
 }
+
+// videoInfo stops waiting for ytdl when ctx is done.
+// ytdl does not know about contexts, so its request is left to finish on its own.
+func videoInfo(ctx context.Context, arg string) (*ytdl.VideoInfo, error) {
+	type result struct {
+		info *ytdl.VideoInfo
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		info, err := ytdl.GetVideoInfo(arg)
+		done <- result{info, err}
+	}()
+	select {
+	case r := <-done:
+		return r.info, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
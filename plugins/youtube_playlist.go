@@ -0,0 +1,107 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// youtube will not list more than 50 items at a time
+const pageLengthYt = 50
+
+// maxPlaylistLengthYt stops very long playlists from using up the api quota
+const maxPlaylistLengthYt = 500
+
+// YoutubePlaylist resolves each video in a youtube playlist.
+// It should be considered before Youtube, which only plays one video.
+type YoutubePlaylist struct {
+	service *youtube.Service
+}
+
+func NewYoutubePlaylist(apikey string) (*YoutubePlaylist, error) {
+	svc, err := newYoutubeService(apikey)
+	if err != nil {
+		return nil, err
+	}
+	return &YoutubePlaylist{service: svc}, nil
+}
+
+// CanHandle urls with a list parameter.
+// Mixes (lists starting with RD) are generated for each viewer and cannot be listed.
+func (ytp YoutubePlaylist) CanHandle(ctx context.Context, arg string) bool {
+	url, err := url.Parse(arg)
+	if err != nil || !url.IsAbs() || !urlRegexpYt.MatchString(url.Hostname()) {
+		return false
+	}
+	list := url.Query().Get("list")
+	return list != "" && !strings.HasPrefix(list, "RD")
+}
+
+// Resolve the first video in the playlist.
+func (ytp YoutubePlaylist) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
+	tracks, err := ytp.ResolvePlaylist(ctx, arg)
+	if err != nil {
+		return
+	}
+	return tracks[0], nil
+}
+
+func (ytp YoutubePlaylist) ResolvePlaylist(ctx context.Context, arg string) ([]Metadata, error) {
+	url, err := url.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	listID := url.Query().Get("list")
+
+	length := maxPlaylistLengthYt
+	if n := limit(ctx); n > 0 && n < length {
+		length = n
+	}
+
+	var tracks []Metadata
+	pageToken := ""
+	for len(tracks) < length {
+		pageLength := pageLengthYt
+		if left := length - len(tracks); left < pageLength {
+			pageLength = left
+		}
+		call := ytp.service.PlaylistItems.List("snippet").
+			PlaylistId(listID).
+			MaxResults(int64(pageLength))
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := call.Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]string, 0, len(resp.Items))
+		for _, item := range resp.Items {
+			ids = append(ids, item.Snippet.ResourceId.VideoId)
+		}
+		// playlist items do not include durations
+		// deleted and private videos are left out
+		page, err := videoMetadata(ctx, ytp.service, ids)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, page...)
+
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	if len(tracks) == 0 {
+		return nil, errors.New("empty playlist")
+	}
+	if len(tracks) > length {
+		tracks = tracks[:length]
+	}
+	return tracks, nil
+}
@@ -1,9 +1,14 @@
 package plugins
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"google.golang.org/api/googleapi/transport"
 	"google.golang.org/api/youtube/v3"
@@ -11,32 +16,41 @@ import (
 
 var httpRegexp = regexp.MustCompile(`http(s)?://`)
 
+// youtube reports durations in ISO 8601 e.g. PT1H2M3S
+var isoDurationRegexp = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+
+const watchURLYt = "https://www.youtube.com/watch?v="
+
 type YoutubeSearch struct {
 	service *youtube.Service
 }
 
 func NewYoutubeSearch(apikey string) (*YoutubeSearch, error) {
-	client := &http.Client{
-		Transport: &transport.APIKey{Key: apikey},
-	}
-	svc, err := youtube.New(client)
+	svc, err := newYoutubeService(apikey)
 	if err != nil {
 		return nil, err
 	}
 	return &YoutubeSearch{service: svc}, nil
 }
 
-func (yts YoutubeSearch) CanHandle(arg string) bool {
+func newYoutubeService(apikey string) (*youtube.Service, error) {
+	client := &http.Client{
+		Transport: &transport.APIKey{Key: apikey},
+	}
+	return youtube.New(client)
+}
+
+func (yts YoutubeSearch) CanHandle(ctx context.Context, arg string) bool {
 	return arg != "" && !httpRegexp.MatchString(arg)
 }
 
-func (yts YoutubeSearch) Resolve(arg string) (md Metadata, err error) {
+func (yts YoutubeSearch) Resolve(ctx context.Context, arg string) (md Metadata, err error) {
 	call := yts.service.Search.List("snippet").
 		Type("video").
 		MaxResults(1).
 		Q(arg)
 
-	resp, err := call.Do()
+	resp, err := call.Context(ctx).Do()
 	if err != nil {
 		return
 	}
@@ -46,5 +60,87 @@ func (yts YoutubeSearch) Resolve(arg string) (md Metadata, err error) {
 		return
 	}
 
-	return Youtube{}.Resolve(resp.Items[0].Id.VideoId)
+	return Youtube{}.Resolve(ctx, resp.Items[0].Id.VideoId)
+}
+
+func (yts YoutubeSearch) Search(ctx context.Context, query string, n int) ([]Metadata, error) {
+	resp, err := yts.service.Search.List("snippet").
+		Type("video").
+		MaxResults(int64(n)).
+		Q(query).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		ids = append(ids, item.Id.VideoId)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no results")
+	}
+
+	// search results do not include durations
+	return videoMetadata(ctx, yts.service, ids)
+}
+
+// videoMetadata looks up at most 50 videos, in the same order as ids.
+// Videos wait until they are opened to resolve a download url.
+func videoMetadata(ctx context.Context, service *youtube.Service, ids []string) ([]Metadata, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	videos, err := service.Videos.List("snippet,contentDetails").
+		Id(strings.Join(ids, ",")).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Metadata, len(videos.Items))
+	for _, v := range videos.Items {
+		url := watchURLYt + v.Id
+		md := Metadata{
+			Title:    v.Snippet.Title,
+			Duration: isoDuration(v.ContentDetails.Duration),
+			OpenFunc: func(ctx context.Context) (io.ReadCloser, error) {
+				md, err := Youtube{}.Resolve(ctx, url)
+				if err != nil {
+					return nil, err
+				}
+				return md.OpenFunc(ctx)
+			},
+			URL:      url,
+			Source:   sourceYt,
+			Uploader: v.Snippet.ChannelTitle,
+		}
+		if v.Snippet.Thumbnails != nil && v.Snippet.Thumbnails.High != nil {
+			md.Thumbnail = v.Snippet.Thumbnails.High.Url
+		}
+		byID[v.Id] = md
+	}
+
+	// videos are not listed in the same order as they were requested
+	results := make([]Metadata, 0, len(ids))
+	for _, id := range ids {
+		if md, ok := byID[id]; ok {
+			results = append(results, md)
+		}
+	}
+	return results, nil
+}
+
+func isoDuration(iso string) time.Duration {
+	matches := isoDurationRegexp.FindStringSubmatch(iso)
+	if matches == nil {
+		return 0
+	}
+	var dur time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		v, _ := strconv.Atoi(matches[i+1])
+		dur += time.Duration(v) * unit
+	}
+	return dur
 }
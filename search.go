@@ -0,0 +1,155 @@
+package musicbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jeffreymkabot/musicbot/plugins"
+	"github.com/pkg/errors"
+)
+
+// DefaultSearchTimeout is how long the results of the search command can be picked from unless the guild configures otherwise.
+const DefaultSearchTimeout = 30 * time.Second
+
+// users react with one of these to pick a search result
+var numberEmoji = []string{"1⃣", "2⃣", "3⃣", "4⃣", "5⃣"}
+
+type pendingSearch struct {
+	// the search command event, songs picked from the results are queued as if they were requested by this event
+	evt     GuildEvent
+	results []plugins.Metadata
+	expires time.Time
+}
+
+var search = command{
+	name:            "search",
+	alias:           []string{"find"},
+	usage:           "search [query]",
+	long:            "List the top results for a query.  React with a number to queue one of them.",
+	restrictChannel: true,
+	run: func(gsvc *GuildService, evt GuildEvent, args []string) error {
+		if len(args) == 0 {
+			return errors.New("query please")
+		}
+		query, available := strings.Join(args, " "), gsvc.plugins()
+		gsvc.lookup(evt, "", func(ctx context.Context) (serviceFunc, error) {
+			results := searchPlugins(ctx, available, query, len(numberEmoji))
+			if len(results) == 0 {
+				return nil, errors.New("no results")
+			}
+			return showSearchResults(query, results), nil
+		})
+		return nil
+	},
+}
+
+// showSearchResults posts the results of a search and waits for one to be picked.
+func showSearchResults(query string, results []plugins.Metadata) serviceFunc {
+	return func(gsvc *GuildService, evt GuildEvent, _ []string) error {
+		msg, err := gsvc.discord.ChannelMessageSendEmbed(evt.ChannelID, searchEmbed(query, results))
+		if err != nil {
+			return err
+		}
+
+		timeout := gsvc.SearchTimeout
+		if timeout <= 0 {
+			timeout = DefaultSearchTimeout
+		}
+		gsvc.pruneSearches()
+		gsvc.searches[msg.ID] = pendingSearch{
+			evt:     evt,
+			results: results,
+			expires: time.Now().Add(timeout),
+		}
+		for i := range results {
+			if err := gsvc.discord.MessageReactionAdd(msg.ChannelID, msg.ID, numberEmoji[i]); err != nil {
+				log.Printf("failed to attach search pick %v", err)
+			}
+		}
+		time.AfterFunc(timeout, func() {
+			gsvc.discord.ChannelMessageDelete(msg.ChannelID, msg.ID)
+		})
+		return nil
+	}
+}
+
+// searchPlugins collects up to n results from the first plugin that can search for the query.
+func searchPlugins(ctx context.Context, available []plugins.Plugin, query string, n int) []plugins.Metadata {
+	for _, pl := range available {
+		searcher, ok := pl.(plugins.Searcher)
+		if !ok || !pl.CanHandle(ctx, query) {
+			continue
+		}
+		results, err := searcher.Search(ctx, query, n)
+		if err != nil {
+			log.Printf("search failed %v", err)
+			continue
+		}
+		if len(results) > n {
+			results = results[:n]
+		}
+		return results
+	}
+	return nil
+}
+
+func searchEmbed(query string, results []plugins.Metadata) *discordgo.MessageEmbed {
+	lines := make([]string, len(results))
+	for i, md := range results {
+		lines[i] = fmt.Sprintf("%s [%s](%s) `%s` %s", numberEmoji[i], md.Title, md.URL, prettyTime(md.Duration), md.Source)
+	}
+	return &discordgo.MessageEmbed{
+		Color:       0xa680ee,
+		Title:       "🔎 " + query,
+		Description: strings.Join(lines, "\n"),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "React with a number to queue a result.",
+		},
+	}
+}
+
+// pickSearchResult queues the search result corresponding to the reacted emoji.
+// The result is picked only by the person who searched.
+// Returns false if the event is not a reaction to the results of a search.
+func (gsvc *GuildService) pickSearchResult(evt GuildEvent) bool {
+	gsvc.pruneSearches()
+	pending, ok := gsvc.searches[evt.MessageID]
+	if !ok {
+		return false
+	}
+	if evt.AuthorID != pending.evt.AuthorID {
+		return true
+	}
+
+	// discord might or might not include the emoji variation selector
+	idx := -1
+	for i, emoji := range numberEmoji {
+		if emoji == strings.Replace(evt.Body, "\ufe0f", "", -1) {
+			idx = i
+		}
+	}
+	if idx < 0 || idx >= len(pending.results) {
+		return true
+	}
+
+	delete(gsvc.searches, evt.MessageID)
+	gsvc.discord.ChannelMessageDelete(evt.ChannelID, evt.MessageID)
+
+	url := pending.results[idx].URL
+	log.Printf("evt %v -> search result %v", evt, url)
+	gsvc.lookup(pending.evt, requeue.ack, findPlugin(gsvc.plugins(), url, gsvc.queueSpace()))
+	return true
+}
+
+func (gsvc *GuildService) pruneSearches() {
+	now := time.Now()
+	for id, pending := range gsvc.searches {
+		if now.After(pending.expires) {
+			delete(gsvc.searches, id)
+		}
+	}
+}